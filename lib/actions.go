@@ -12,6 +12,12 @@ const (
 	ActionDelete   = "delete"
 	ActionRecreate = "recreate"
 	ActionInject   = "inject"
+	ActionPlan     = "plan"
+	ActionDiff     = "diff"
+	ActionHistory  = "history"
+	ActionRollback = "rollback"
+	ActionValidate = "validate"
+	ActionDeps     = "deps"
 )
 
 var (
@@ -21,6 +27,18 @@ var (
 		ActionDelete:   true,
 		ActionRecreate: true,
 		ActionInject:   true,
+		ActionPlan:     true,
+		ActionDiff:     true,
+		ActionHistory:  true,
+		ActionRollback: true,
+		ActionValidate: true,
+		ActionDeps:     true,
+	}
+
+	// Actions that do not take a target
+	TargetlessActions = map[string]bool{
+		ActionHistory:  true,
+		ActionValidate: true,
 	}
 )
 
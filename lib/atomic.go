@@ -0,0 +1,51 @@
+package kubemgr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/apourchet/kubemgr/lib/kubectl"
+	"github.com/golang/glog"
+)
+
+// Records the live state of the resource before it is applied, so that an
+// atomic apply can be rolled back
+func (r *ResourceManager) recordSnapshot(ctx context.Context, step PlanStep) error {
+	if !Atomic {
+		return nil
+	}
+	if kubectl.DryRun != "" {
+		glog.Infof("%sDry run: not recording the live state of '%s'", kubectl.LogPrefix(ctx), step.Path)
+		return nil
+	}
+
+	snapshot, err := kubectl.TakeSnapshot(ctx, r.Client, step.Path)
+	if err != nil {
+		return fmt.Errorf("Failed to record the live state of %s: %v", step.Resource, err)
+	}
+	r.mutex.Lock()
+	r.snapshots = append(r.snapshots, snapshot)
+	r.mutex.Unlock()
+	return nil
+}
+
+// Undoes an apply that failed with the given error, restoring the recorded
+// resources in the reverse order from which they were applied. Restoring goes
+// on past failures so that as much as possible is put back.
+func (r *ResourceManager) rollback(applyErr error) error {
+	glog.Errorf("Apply failed, rolling back %d resource(s): %v", len(r.snapshots), applyErr)
+	failures := []string{}
+	for i := len(r.snapshots) - 1; i >= 0; i-- {
+		err := r.snapshots[i].Restore(context.Background(), r.Client)
+		if err != nil {
+			glog.Errorf("Failed to restore '%s': %v", r.snapshots[i].FilePath, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", r.snapshots[i].FilePath, err))
+		}
+	}
+	r.snapshots = nil
+	if len(failures) > 0 {
+		return fmt.Errorf("%v; rollback failed: %s", applyErr, strings.Join(failures, "; "))
+	}
+	return fmt.Errorf("%v; rolled back", applyErr)
+}
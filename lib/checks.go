@@ -0,0 +1,257 @@
+package kubemgr
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apourchet/kubemgr/lib/kubectl"
+	"github.com/golang/glog"
+)
+
+// Check is a custom health check run once the objects of a resource are
+// ready, before any of its dependents is applied. Exactly one of Command,
+// HTTP or Exec must be set. The check is retried every Interval until it
+// succeeds or Timeout is reached.
+type Check struct {
+	Name     string
+	Command  []string
+	HTTP     *HTTPCheck
+	Exec     *ExecCheck
+	Timeout  string
+	Interval string
+}
+
+// HTTPCheck probes a path of a pod or service through kubectl port-forward.
+// Any 2xx status passes unless Status is set.
+type HTTPCheck struct {
+	Target    string
+	Namespace string
+	Port      int
+	Path      string
+	Status    int
+}
+
+// ExecCheck runs a command inside of a pod through kubectl exec
+type ExecCheck struct {
+	Target    string
+	Namespace string
+	Container string
+	Command   []string
+}
+
+var (
+	forwardingRegexp = regexp.MustCompile(`Forwarding from 127\.0\.0\.1:(\d+)`)
+)
+
+func (c Check) Validate() error {
+	kinds := 0
+	if len(c.Command) > 0 {
+		kinds++
+	}
+	if c.HTTP != nil {
+		kinds++
+		if c.HTTP.Target == "" || c.HTTP.Port <= 0 {
+			return fmt.Errorf("HTTP check needs a target and a port")
+		}
+	}
+	if c.Exec != nil {
+		kinds++
+		if c.Exec.Target == "" || len(c.Exec.Command) == 0 {
+			return fmt.Errorf("Exec check needs a target and a command")
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("Check must have exactly one of command, http or exec")
+	}
+	_, _, err := c.durations()
+	return err
+}
+
+func (c Check) String() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	if c.HTTP != nil {
+		return fmt.Sprintf("http %s:%d%s", c.HTTP.Target, c.HTTP.Port, c.HTTP.Path)
+	}
+	if c.Exec != nil {
+		return fmt.Sprintf("exec %s: %s", c.Exec.Target, strings.Join(c.Exec.Command, " "))
+	}
+	return strings.Join(c.Command, " ")
+}
+
+// Runs the check until it passes, giving up once its timeout is reached or
+// the context is cancelled
+func (c Check) Run(ctx context.Context) error {
+	prefix := kubectl.LogPrefix(ctx)
+	if kubectl.DryRun != "" {
+		glog.Infof("%sDry run: skipping check '%s'", prefix, c)
+		return nil
+	}
+
+	timeout, interval, err := c.durations()
+	if err != nil {
+		return err
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	glog.Infof("%sRunning check '%s'", prefix, c)
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-checkCtx.Done():
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return fmt.Errorf("Check '%s' still failing after %v: %v", c, timeout, err)
+			case <-time.After(interval):
+			}
+		}
+
+		err = c.runOnce(checkCtx)
+		if err == nil {
+			glog.Infof("%sCheck '%s' passed", prefix, c)
+			return nil
+		}
+		glog.Infof("%sCheck '%s' failed: %v", prefix, c, err)
+	}
+}
+
+func (c Check) runOnce(ctx context.Context) error {
+	switch {
+	case c.HTTP != nil:
+		return c.HTTP.run(ctx)
+	case c.Exec != nil:
+		return c.Exec.run(ctx)
+	}
+	return runCommand(ctx, nil, c.Command[0], c.Command[1:]...)
+}
+
+func (c Check) durations() (time.Duration, time.Duration, error) {
+	interval := kubectl.CheckSleep
+	var err error
+	if c.Interval != "" {
+		interval, err = time.ParseDuration(c.Interval)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Invalid check interval: %v", err)
+		}
+		if interval <= 0 {
+			return 0, 0, fmt.Errorf("Invalid check interval: must be positive")
+		}
+	}
+	timeout := time.Duration(kubectl.CheckRetries) * interval
+	if c.Timeout != "" {
+		timeout, err = time.ParseDuration(c.Timeout)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Invalid check timeout: %v", err)
+		}
+	}
+	return timeout, interval, nil
+}
+
+// Forwards a local port to the target and probes it for as long as the
+// forwarding lasts
+func (h *HTTPCheck) run(ctx context.Context) error {
+	forwardCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	args := []string{"port-forward", h.Target, fmt.Sprintf(":%d", h.Port)}
+	args = append(args, namespaceArgs(h.Namespace)...)
+	args = append(args, kubectl.ContextArgs()...)
+	cmd := exec.CommandContext(forwardCtx, "kubectl", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		cancel()
+		cmd.Wait()
+	}()
+
+	localPort := 0
+	scanner := bufio.NewScanner(stdout)
+	for localPort == 0 && scanner.Scan() {
+		if match := forwardingRegexp.FindStringSubmatch(scanner.Text()); match != nil {
+			localPort, _ = strconv.Atoi(match[1])
+		}
+	}
+	if localPort == 0 {
+		return fmt.Errorf("Failed to port-forward to %s:%d", h.Target, h.Port)
+	}
+	go ioutil.ReadAll(stdout)
+
+	probeURL := fmt.Sprintf("http://127.0.0.1:%d/%s", localPort, strings.TrimPrefix(h.Path, "/"))
+	req, err := http.NewRequest("GET", probeURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if h.Status != 0 && resp.StatusCode != h.Status {
+		return fmt.Errorf("Got status %d, want %d", resp.StatusCode, h.Status)
+	} else if h.Status == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		return fmt.Errorf("Got status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (e *ExecCheck) run(ctx context.Context) error {
+	args := []string{"exec", e.Target}
+	args = append(args, namespaceArgs(e.Namespace)...)
+	if e.Container != "" {
+		args = append(args, "-c", e.Container)
+	}
+	args = append(args, kubectl.ContextArgs()...)
+	args = append(args, "--")
+	args = append(args, e.Command...)
+	return runCommand(ctx, nil, "kubectl", args...)
+}
+
+// Runs the checks of the resource one after the other
+func (r *ResourceManager) runChecks(ctx context.Context, resourceName string) error {
+	for _, check := range r.Resources[resourceName].Checks {
+		err := check.Run(ctx)
+		if err != nil {
+			return fmt.Errorf("Check of %s failed: %v", resourceName, err)
+		}
+	}
+	return nil
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+func runCommand(ctx context.Context, env []string, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	glog.V(2).Infof("%s%s => %s", kubectl.LogPrefix(ctx), name, strings.TrimSpace(string(out)))
+	return nil
+}
+
+func namespaceArgs(namespace string) []string {
+	if namespace == "" {
+		return []string{}
+	}
+	return []string{"--namespace", namespace}
+}
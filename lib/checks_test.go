@@ -0,0 +1,109 @@
+package kubemgr
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// Adds the fields to the declaration of db-dp in the shop configuration
+func extendShopDB(t *testing.T, fields string) {
+	config := strings.Replace(shopConfig, `"deps": ["db-svc"]}`, `"deps": ["db-svc"], `+fields+`}`, 1)
+	err := ioutil.WriteFile("kubeconfig.json", []byte(config), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readLines(t *testing.T, filePath string) []string {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(string(content))
+}
+
+func TestChecksRunOnceReady(t *testing.T) {
+	cluster := newShop(t)
+	extendShopDB(t, `"checks": [
+        {"name": "db-ping", "command": ["sh", "-c", "echo ping >> checked"]},
+        {"command": ["sh", "-c", "echo query >> checked"]}
+    ]`)
+	err := loadShop(t, cluster).ApplyResources("app-dp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOrder(t, "apply order", cluster.applied, "db-svc", "db-dp", "app-dp")
+	assertOrder(t, "checks", readLines(t, "checked"), "ping", "query")
+
+	// The check action runs them again without applying anything
+	err = loadShop(t, cluster).CheckResources("db-dp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOrder(t, "checks", readLines(t, "checked"), "ping", "query", "ping", "query")
+	assertOrder(t, "apply order", cluster.applied, "db-svc", "db-dp", "app-dp")
+}
+
+func TestCheckIsRetriedUntilItPasses(t *testing.T) {
+	cluster := newShop(t)
+	extendShopDB(t, `"checks": [{
+        "command": ["sh", "-c", "echo attempt >> attempts; [ $(wc -l < attempts) -ge 3 ]"],
+        "interval": "10ms", "timeout": "10s"
+    }]`)
+	err := loadShop(t, cluster).ApplyResources("app-dp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts := readLines(t, "attempts"); len(attempts) != 3 {
+		t.Errorf("Check ran %d times, want 3", len(attempts))
+	}
+	assertOrder(t, "apply order", cluster.applied, "db-svc", "db-dp", "app-dp")
+}
+
+func TestFailingCheckStopsTheApply(t *testing.T) {
+	cluster := newShop(t)
+	extendShopDB(t, `"checks": [{
+        "name": "db-ping",
+        "command": ["sh", "-c", "echo attempt >> attempts; echo db not ready >&2; exit 1"],
+        "interval": "20ms", "timeout": "100ms"
+    }]`)
+	err := loadShop(t, cluster).ApplyResources("app-dp")
+	if err == nil {
+		t.Fatal("Apply went through with a failing check")
+	}
+	for _, want := range []string{"Check of db-dp failed", "'db-ping' still failing after 100ms", "db not ready"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Got %v, want %q in it", err, want)
+		}
+	}
+	if attempts := readLines(t, "attempts"); len(attempts) < 2 {
+		t.Errorf("Check ran %d time(s), want it retried", len(attempts))
+	}
+	// The dependents of db-dp are never applied
+	assertOrder(t, "apply order", cluster.applied, "db-svc", "db-dp")
+}
+
+func TestCheckValidation(t *testing.T) {
+	for _, tc := range []struct {
+		check Check
+		err   string
+	}{
+		{Check{Command: []string{"true"}}, ""},
+		{Check{HTTP: &HTTPCheck{Target: "svc/db", Port: 80}, Interval: "1s", Timeout: "1m"}, ""},
+		{Check{Exec: &ExecCheck{Target: "deploy/db", Command: []string{"pg_isready"}}}, ""},
+		{Check{}, "exactly one of"},
+		{Check{Command: []string{"true"}, HTTP: &HTTPCheck{Target: "svc/db", Port: 80}}, "exactly one of"},
+		{Check{HTTP: &HTTPCheck{Target: "svc/db"}}, "needs a target and a port"},
+		{Check{Exec: &ExecCheck{Target: "deploy/db"}}, "needs a target and a command"},
+		{Check{Command: []string{"true"}, Interval: "0s"}, "must be positive"},
+		{Check{Command: []string{"true"}, Timeout: "soon"}, "Invalid check timeout"},
+	} {
+		err := tc.check.Validate()
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: got %v, want no error", tc.check, err)
+		} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: got %v, want %q", tc.check, err, tc.err)
+		}
+	}
+}
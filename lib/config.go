@@ -0,0 +1,321 @@
+package kubemgr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the content of a configuration file. Every file is loaded and
+// validated once, and shared by the imports, the injects and the resources.
+type Config struct {
+	Package   string
+	Context   string
+	Metadata  MetadataConfig
+	Imports   []Import
+	Injects   []Inject
+	Resources map[string]Resource
+}
+
+// Problem is something wrong with a configuration, located in its file
+type Problem struct {
+	File    string
+	Line    int
+	Path    string
+	Message string
+}
+
+// ConfigError holds every problem found in a configuration
+type ConfigError struct {
+	Problems []Problem
+}
+
+// configNode is a decoded JSON or YAML value that remembers the line it was
+// written on, so that problems can point back to it
+type configNode struct {
+	Kind     string
+	Line     int
+	Keys     []string
+	KeyLines []int
+	Values   []*configNode
+	Value    interface{}
+}
+
+const (
+	kindObject  = "object"
+	kindArray   = "array"
+	kindString  = "string"
+	kindNumber  = "number"
+	kindBoolean = "boolean"
+	kindNull    = "null"
+)
+
+var (
+	configCache = make(map[string]*Config)
+	configMutex sync.Mutex
+)
+
+// Loads the configuration file, rejecting anything the schema does not allow.
+// The result is cached so that the file is only read once.
+func LoadConfig(filePath string) (*Config, error) {
+	filePath = path.Clean(filePath)
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if config, found := configCache[filePath]; found {
+		return config, nil
+	}
+
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := parseConfigTree(filePath, content)
+	if err != nil {
+		return nil, err
+	}
+	problems := validateSchema(tree)
+	if len(problems) > 0 {
+		for i := range problems {
+			problems[i].File = filePath
+		}
+		return nil, &ConfigError{Problems: problems}
+	}
+
+	config := Config{}
+	err = unmarshalStrict(filePath, content, &config)
+	if err != nil {
+		return nil, &ConfigError{Problems: []Problem{{File: filePath, Message: err.Error()}}}
+	}
+	locateResources(&config, tree, filePath)
+
+	configCache[filePath] = &config
+	return &config, nil
+}
+
+// Decodes what it can of a configuration that failed to load, so that its
+// imports and resources can still be validated
+func lenientConfig(filePath string) *Config {
+	config := Config{}
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return &config
+	}
+	unmarshalFile(filePath, content, &config)
+	tree, err := parseConfigTree(filePath, content)
+	if err != nil {
+		tree = &configNode{}
+	}
+	locateResources(&config, tree, filePath)
+	return &config
+}
+
+// Drops the cached configuration of the file, so that it is read again once
+// it has changed
+func forgetConfig(filePath string) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	delete(configCache, path.Clean(filePath))
+}
+
+func (p Problem) String() string {
+	location := p.File
+	if p.Line > 0 {
+		location += fmt.Sprintf(":%d", p.Line)
+	}
+	parts := []string{}
+	for _, part := range []string{location, p.Path, p.Message} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ": ")
+}
+
+func (e *ConfigError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		lines[i] = problem.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Returns the value of the object's field, matched the same way as the JSON
+// decoder does, without regard to case
+func (n *configNode) field(name string) *configNode {
+	if n == nil || n.Kind != kindObject {
+		return nil
+	}
+	for i, key := range n.Keys {
+		if strings.EqualFold(key, name) {
+			return n.Values[i]
+		}
+	}
+	return nil
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+// Records the package of every resource along with where it is declared
+func locateResources(config *Config, tree *configNode, filePath string) {
+	resourceLines := make(map[string]int)
+	if resources := tree.field("resources"); resources != nil {
+		for i, name := range resources.Keys {
+			resourceLines[name] = resources.KeyLines[i]
+		}
+	}
+	for name, res := range config.Resources {
+		res.Package = config.Package
+		res.Key = name
+		res.File = filePath
+		res.Line = resourceLines[name]
+		config.Resources[name] = res
+	}
+}
+
+func parseConfigTree(filePath string, content []byte) (*configNode, error) {
+	if isYAML(filePath, content) {
+		return parseYAMLTree(filePath, content)
+	}
+	return parseJSONTree(filePath, content)
+}
+
+func parseJSONTree(filePath string, content []byte) (*configNode, error) {
+	newlines := []int{}
+	for i, c := range content {
+		if c == '\n' {
+			newlines = append(newlines, i)
+		}
+	}
+	lineAt := func(offset int64) int {
+		return sort.SearchInts(newlines, int(offset)) + 1
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	node, err := readJSONNode(decoder, lineAt)
+	if err != nil {
+		problem := Problem{File: filePath, Message: err.Error()}
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			problem.Line = lineAt(syntaxErr.Offset)
+		}
+		return nil, &ConfigError{Problems: []Problem{problem}}
+	}
+	return node, nil
+}
+
+func readJSONNode(decoder *json.Decoder, lineAt func(int64) int) (*configNode, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	node := &configNode{Line: lineAt(decoder.InputOffset()), Value: token}
+	switch t := token.(type) {
+	case json.Delim:
+		node.Value = nil
+		node.Kind = kindArray
+		if t == '{' {
+			node.Kind = kindObject
+		}
+		for decoder.More() {
+			if node.Kind == kindObject {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				node.Keys = append(node.Keys, fmt.Sprint(key))
+				node.KeyLines = append(node.KeyLines, lineAt(decoder.InputOffset()))
+			}
+			child, err := readJSONNode(decoder, lineAt)
+			if err != nil {
+				return nil, err
+			}
+			node.Values = append(node.Values, child)
+		}
+		_, err = decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+	case string:
+		node.Kind = kindString
+	case json.Number:
+		node.Kind = kindNumber
+		node.Value, _ = t.Float64()
+	case bool:
+		node.Kind = kindBoolean
+	case nil:
+		node.Kind = kindNull
+	}
+	return node, nil
+}
+
+// Only yaml.v3 keeps the line of every node. The configuration itself is
+// decoded through ghodss/yaml, like the manifests, so that YAML and JSON files
+// map onto Config the same way.
+func parseYAMLTree(filePath string, content []byte) (*configNode, error) {
+	doc := yaml.Node{}
+	err := yaml.Unmarshal(content, &doc)
+	if err != nil {
+		return nil, &ConfigError{Problems: []Problem{{File: filePath, Message: err.Error()}}}
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil, &ConfigError{Problems: []Problem{{File: filePath, Message: "empty configuration"}}}
+	}
+	return convertYAMLNode(doc.Content[0])
+}
+
+func convertYAMLNode(n *yaml.Node) (*configNode, error) {
+	node := &configNode{Line: n.Line}
+	switch n.Kind {
+	case yaml.AliasNode:
+		return convertYAMLNode(n.Alias)
+	case yaml.MappingNode:
+		node.Kind = kindObject
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			child, err := convertYAMLNode(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			node.Keys = append(node.Keys, n.Content[i].Value)
+			node.KeyLines = append(node.KeyLines, n.Content[i].Line)
+			node.Values = append(node.Values, child)
+		}
+	case yaml.SequenceNode:
+		node.Kind = kindArray
+		for _, item := range n.Content {
+			child, err := convertYAMLNode(item)
+			if err != nil {
+				return nil, err
+			}
+			node.Values = append(node.Values, child)
+		}
+	case yaml.ScalarNode:
+		var err error
+		switch n.ShortTag() {
+		case "!!int", "!!float":
+			node.Kind = kindNumber
+			number := 0.0
+			err = n.Decode(&number)
+			node.Value = number
+		case "!!bool":
+			node.Kind = kindBoolean
+			err = n.Decode(&node.Value)
+		case "!!null":
+			node.Kind = kindNull
+		default:
+			node.Kind = kindString
+			node.Value = n.Value
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
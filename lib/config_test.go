@@ -0,0 +1,154 @@
+package kubemgr
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	filePath := path.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(filePath, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+// Loads the configuration, expecting it to be rejected with these problems
+func configProblems(t *testing.T, name, content string) []Problem {
+	filePath := writeConfig(t, name, content)
+	_, err := LoadConfig(filePath)
+	configErr, ok := err.(*ConfigError)
+	if !ok {
+		t.Fatalf("%s: got %v, want a configuration error", name, err)
+	}
+	for _, problem := range configErr.Problems {
+		if problem.File != filePath {
+			t.Errorf("Got problem in %s, want %s", problem.File, filePath)
+		}
+	}
+	return configErr.Problems
+}
+
+func assertProblem(t *testing.T, problems []Problem, line int, nodePath, message string) {
+	t.Helper()
+	for _, problem := range problems {
+		if problem.Line == line && problem.Path == nodePath && problem.Message == message {
+			return
+		}
+	}
+	t.Errorf("Got problems %v, want line %d: %s: %s", problems, line, nodePath, message)
+}
+
+func TestConfigUnknownFields(t *testing.T) {
+	problems := configProblems(t, "kubeconfig.json", `{
+    "package": "shop",
+    "resources": {
+        "db-dp": {
+            "path": "k8s/db-dp.json",
+            "dpes": ["db-svc"]
+        }
+    },
+    "injcets": []
+}`)
+	if len(problems) != 2 {
+		t.Errorf("Got problems %v, want 2", problems)
+	}
+	assertProblem(t, problems, 6, "resources.db-dp", `unknown field "dpes", did you mean "deps"?`)
+	assertProblem(t, problems, 9, "", `unknown field "injcets", did you mean "injects"?`)
+
+	// Nothing close enough to suggest
+	problems = configProblems(t, "kubeconfig.json", `{"package": "shop", "replicas": 2}`)
+	assertProblem(t, problems, 1, "", `unknown field "replicas"`)
+}
+
+func TestConfigTypeErrors(t *testing.T) {
+	problems := configProblems(t, "kubeconfig.json", `{
+    "package": "shop",
+    "resources": {
+        "db-dp": {"path": "k8s/db-dp.json", "deps": "db-svc"},
+        "app-dp": {"deps": ["db-dp"], "readiness": {"backoff": "fast"}}
+    }
+}`)
+	assertProblem(t, problems, 4, "resources.db-dp.deps", "expected array, got string")
+	assertProblem(t, problems, 5, "resources.app-dp", `missing required field "path"`)
+	assertProblem(t, problems, 5, "resources.app-dp.readiness.backoff", "expected number, got string")
+}
+
+func TestConfigYAMLProblems(t *testing.T) {
+	problems := configProblems(t, "kubeconfig.yaml", `package: shop
+resources:
+  db-dp:
+    path: k8s/db-dp.json
+    deps: db-svc
+    readiness:
+      backoff: 0.5
+  app-dp:
+    path: k8s/app-dp.json
+    rolOn: [db-config]
+`)
+	if len(problems) != 3 {
+		t.Errorf("Got problems %v, want 3", problems)
+	}
+	assertProblem(t, problems, 5, "resources.db-dp.deps", "expected array, got string")
+	assertProblem(t, problems, 7, "resources.db-dp.readiness.backoff", "0.5 is less than 1")
+	assertProblem(t, problems, 10, "resources.app-dp", `unknown field "rolOn", did you mean "rollOn"?`)
+
+	// Syntax errors are reported as they are
+	problems = configProblems(t, "kubeconfig.yaml", "package: [shop\n")
+	if len(problems) != 1 || !strings.Contains(problems[0].Message, "yaml") {
+		t.Errorf("Got problems %v, want the syntax error", problems)
+	}
+	problems = configProblems(t, "kubeconfig.json", "{\n    \"package\": \"shop\",\n}")
+	if len(problems) != 1 || problems[0].Line != 2 {
+		t.Errorf("Got problems %v, want the trailing comma on line 2", problems)
+	}
+}
+
+func TestConfigLocatesResources(t *testing.T) {
+	for name, content := range map[string]string{
+		"kubeconfig.json": "{\n    \"package\": \"shop\",\n    \"resources\": {\n        \"db-svc\": {\"path\": \"k8s/db-svc.json\"}\n    }\n}",
+		"kubeconfig.yaml": "package: shop\n\nresources:\n  db-svc:\n    path: k8s/db-svc.json\n",
+	} {
+		filePath := writeConfig(t, name, content)
+		config, err := LoadConfig(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := config.Resources["db-svc"]
+		if res.Path != "k8s/db-svc.json" || res.Package != "shop" || res.File != filePath || res.Line != 4 {
+			t.Errorf("%s: got resource %+v, want it on line 4", name, res)
+		}
+	}
+}
+
+func TestLenientConfig(t *testing.T) {
+	filePath := writeConfig(t, "kubeconfig.yaml", `package: shop
+imports:
+  - path: ../db/kubeconfig.json
+resources:
+  db-svc:
+    path: k8s/db-svc.json
+    color: blue
+`)
+	if _, err := LoadConfig(filePath); err == nil {
+		t.Fatal("Configuration with an unknown field was loaded")
+	}
+
+	// What could be decoded is still there for the validation to go on
+	config := lenientConfig(filePath)
+	if len(config.Imports) != 1 || config.Imports[0].Path != "../db/kubeconfig.json" {
+		t.Errorf("Got imports %+v", config.Imports)
+	}
+	if res := config.Resources["db-svc"]; res.Path != "k8s/db-svc.json" || res.Line != 5 {
+		t.Errorf("Got resource %+v, want it on line 5", res)
+	}
+
+	// Nothing can be decoded from a file that is not valid YAML
+	config = lenientConfig(writeConfig(t, "kubeconfig.yaml", "resources: [\n"))
+	if len(config.Resources) != 0 {
+		t.Errorf("Got resources %v from a broken file", config.Resources)
+	}
+}
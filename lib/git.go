@@ -0,0 +1,105 @@
+package kubemgr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// GitPackage is a repository imported at a ref. It is mirrored in the cache,
+// and every commit it is imported at is checked out next to the mirror.
+type GitPackage struct {
+	URL string
+	Ref string
+	Dir string
+}
+
+func NewGitPackage(gitURL, ref string) *GitPackage {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	sum := sha256.Sum256([]byte(gitURL))
+	return &GitPackage{
+		URL: gitURL,
+		Ref: ref,
+		Dir: path.Join(CacheDir, "git", hex.EncodeToString(sum[:8])),
+	}
+}
+
+// Fetches the repository and returns the commit that the ref points to
+func (g *GitPackage) Resolve() (string, error) {
+	err := g.fetch()
+	if err != nil {
+		return "", err
+	}
+	commit, err := g.git("rev-parse", "--verify", "--quiet", g.Ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve %s at %s: %v", g.URL, g.Ref, err)
+	}
+	return commit, nil
+}
+
+// Checks the commit out in the cache unless it already is, and returns the
+// directory it is checked out in
+func (g *GitPackage) Checkout(commit string) (string, error) {
+	dir := path.Join(g.Dir, commit)
+	exists, err := fileExists(dir)
+	if err != nil || exists {
+		return dir, err
+	}
+	if _, err := g.git("cat-file", "-e", commit+"^{commit}"); err != nil {
+		err = g.fetch()
+		if err != nil {
+			return "", err
+		}
+	}
+	glog.Infof("Checking out %s at %s", g.URL, commit)
+	_, err = g.git("worktree", "add", "--detach", dir, commit)
+	return dir, err
+}
+
+func (g *GitPackage) fetch() error {
+	exists, err := fileExists(g.repo())
+	if err != nil {
+		return err
+	}
+	if !exists {
+		glog.Infof("Cloning %s", g.URL)
+		return runGit("clone", "--mirror", "--quiet", "--", g.URL, g.repo())
+	}
+	glog.Infof("Fetching %s", g.URL)
+	_, err = g.git("fetch", "--prune", "--quiet", "origin")
+	return err
+}
+
+func (g *GitPackage) repo() string {
+	return path.Join(g.Dir, "repo.git")
+}
+
+func (g *GitPackage) git(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"--git-dir", g.repo()}, args...)...)
+	stderr := bytes.Buffer{}
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+func runGit(args ...string) error {
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
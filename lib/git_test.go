@@ -0,0 +1,142 @@
+package kubemgr
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Runs git in the directory, with an identity to commit as
+func gitIn(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	args = append([]string{"-c", "user.name=kubemgr", "-c", "user.email=kubemgr@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Commits the files to the repository, and returns the commit
+func commitFiles(t *testing.T, repo string, files map[string]string) string {
+	for file, content := range files {
+		err := os.MkdirAll(path.Dir(path.Join(repo, file)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path.Join(repo, file), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	gitIn(t, repo, "add", "-A")
+	gitIn(t, repo, "commit", "--quiet", "-m", "Update")
+	return gitIn(t, repo, "rev-parse", "HEAD")
+}
+
+// Creates a repository holding the infra package, tagged v1 at its first
+// commit, and returns its file:// URL along with the tagged commit
+func newInfraRepo(t *testing.T) (string, string) {
+	repo := t.TempDir()
+	gitIn(t, repo, "init", "--quiet")
+	tagged := commitFiles(t, repo, map[string]string{
+		"infra/kubeconfig.json":  `{"package": "infra", "imports": [{"path": "../common/kubeconfig.json"}]}`,
+		"common/kubeconfig.json": `{"package": "common"}`,
+	})
+	gitIn(t, repo, "tag", "v1")
+	commitFiles(t, repo, map[string]string{
+		"infra/kubeconfig.json": `{"package": "infra-next"}`,
+	})
+	return "file://" + repo, tagged
+}
+
+func TestGitImportIsLockedToItsRef(t *testing.T) {
+	useCacheDir(t)
+	useFrozen(t, false)
+	gitURL, tagged := newInfraRepo(t)
+	inPackageDir(t, map[string]string{
+		"kubeconfig.json": `{"imports": [{"git": "` + gitURL + `", "ref": "v1", "path": "infra/kubeconfig.json"}]}`,
+	})
+
+	importManager := NewImportManager().(*ImportManager)
+	imports, err := importManager.GetImports("kubeconfig.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports, err = importManager.GetImportClosure(imports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit := importManager.Resolved.GitCommit(gitURL, "v1"); commit != tagged {
+		t.Errorf("Got v1 resolved to %q, want %s", commit, tagged)
+	}
+
+	// The repository is mirrored once, and the commit checked out next to it
+	pkg := NewGitPackage(gitURL, "v1")
+	for _, dir := range []string{path.Join(pkg.Dir, "repo.git"), path.Join(pkg.Dir, tagged)} {
+		if exists, _ := fileExists(dir); !exists {
+			t.Errorf("%s is missing from the cache", dir)
+		}
+	}
+	want := []string{path.Join(pkg.Dir, tagged, "infra/kubeconfig.json"), path.Join(pkg.Dir, tagged, "common/kubeconfig.json")}
+	if strings.Join(imports, ",") != strings.Join(want, ",") {
+		t.Fatalf("Got imports %v, want %v", imports, want)
+	}
+	config, err := LoadConfig(imports[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Package != "infra" {
+		t.Errorf("Got package %q, want the one of v1", config.Package)
+	}
+
+	// The relative import is locked at the commit of the repository
+	err = importManager.SaveLock(imports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock, err := LoadLock(LockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{}
+	for _, locked := range lock.Imports {
+		if locked.Git == gitURL && locked.Commit == tagged {
+			paths = append(paths, locked.Path)
+		}
+	}
+	if strings.Join(paths, ",") != "common/kubeconfig.json,infra/kubeconfig.json" {
+		t.Errorf("Got locked imports %v", lock.Imports)
+	}
+}
+
+func TestGitImportsStayInTheRepository(t *testing.T) {
+	useCacheDir(t)
+	useFrozen(t, false)
+	repo := t.TempDir()
+	gitIn(t, repo, "init", "--quiet")
+	commitFiles(t, repo, map[string]string{
+		"infra/kubeconfig.json": `{"imports": [{"path": "../../secrets/kubeconfig.json"}]}`,
+	})
+	gitURL := "file://" + repo
+
+	for _, imp := range []string{
+		`{"git": "` + gitURL + `", "path": "../kubeconfig.json"}`,
+		`{"git": "` + gitURL + `", "path": "infra/kubeconfig.json"}`,
+	} {
+		inPackageDir(t, map[string]string{"kubeconfig.json": `{"imports": [` + imp + `]}`})
+		importManager := NewImportManager()
+		imports, err := importManager.GetImports("kubeconfig.json")
+		if err == nil {
+			_, err = importManager.GetImportClosure(imports)
+		}
+		if err == nil || !strings.Contains(err.Error(), "outside of the repository") {
+			t.Errorf("%s: got %v, want an error", imp, err)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package kubemgr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DependencyGraph maps every resource name to the sorted list of concrete
+// resource names it depends on, with glob dependencies already expanded.
+type DependencyGraph map[string][]string
+
+func (r *ResourceManager) dependencyGraph() DependencyGraph {
+	return r.graphOf(func(res Resource) []string { return res.Deps })
+}
+
+// Builds the graph whose edges are the resource patterns returned by the
+// function, expanded to the resources they match
+func (r *ResourceManager) graphOf(patterns func(res Resource) []string) DependencyGraph {
+	graph := make(DependencyGraph)
+	for resourceName, res := range r.Resources {
+		edges := make(map[string]interface{})
+		for _, dep := range patterns(res) {
+			for _, match := range r.findMatchingResources(dep) {
+				edges[match] = true
+			}
+		}
+		deps := mapKeys(edges)
+		sort.Strings(deps)
+		graph[resourceName] = deps
+	}
+	return graph
+}
+
+// Returns the nodes of the graph sorted by name
+func (g DependencyGraph) Nodes() []string {
+	nodes := make([]string, 0, len(g))
+	for node := range g {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Returns a cycle for every group of nodes that depend on each other (every
+// strongly connected component of the graph). Each cycle is a path starting
+// and ending with the first node of its group, going through all of the
+// nodes of the group, so that no node of a cycle is left out.
+func (g DependencyGraph) Cycles() [][]string {
+	index := make(map[string]int)
+	lowlink := make(map[string]int)
+	onStack := make(map[string]bool)
+	stack := []string{}
+	cycles := [][]string{}
+
+	// Tarjan's algorithm
+	var visit func(node string)
+	visit = func(node string) {
+		index[node] = len(index)
+		lowlink[node] = index[node]
+		stack = append(stack, node)
+		onStack[node] = true
+		for _, dep := range g[node] {
+			if _, found := index[dep]; !found {
+				visit(dep)
+				if lowlink[dep] < lowlink[node] {
+					lowlink[node] = lowlink[dep]
+				}
+			} else if onStack[dep] && index[dep] < lowlink[node] {
+				lowlink[node] = index[dep]
+			}
+		}
+		if lowlink[node] != index[node] {
+			return
+		}
+
+		component := []string{}
+		for {
+			last := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[last] = false
+			component = append(component, last)
+			if last == node {
+				break
+			}
+		}
+		if len(component) > 1 || containsString(g[node], node) {
+			cycles = append(cycles, g.walkThrough(component))
+		}
+	}
+
+	for _, node := range g.Nodes() {
+		if _, found := index[node]; !found {
+			visit(node)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i][0] < cycles[j][0]
+	})
+	return cycles
+}
+
+// Returns the graph with every edge reversed, mapping each node to the nodes
+// that depend on it.
+func (g DependencyGraph) Reverse() DependencyGraph {
+	reversed := make(DependencyGraph)
+	for _, node := range g.Nodes() {
+		if _, found := reversed[node]; !found {
+			reversed[node] = []string{}
+		}
+		for _, dep := range g[node] {
+			reversed[dep] = append(reversed[dep], node)
+		}
+	}
+	return reversed
+}
+
+// Returns the given nodes along with all of their transitive dependencies,
+// sorted by name.
+func (g DependencyGraph) Closure(nodes []string) []string {
+	seen := make(map[string]interface{})
+	queue := append([]string{}, nodes...)
+	for i := 0; i < len(queue); i++ {
+		if _, found := seen[queue[i]]; found {
+			continue
+		}
+		seen[queue[i]] = true
+		queue = append(queue, g[queue[i]]...)
+	}
+	closure := mapKeys(seen)
+	sort.Strings(closure)
+	return closure
+}
+
+// Orders the given nodes so that every node comes after the nodes it depends
+// on. Dependencies on nodes outside of the given set are ignored, and ties are
+// broken by name so that the order is stable between runs.
+func (g DependencyGraph) TopologicalOrder(nodes []string) ([]string, error) {
+	included := make(map[string]bool)
+	for _, node := range nodes {
+		included[node] = true
+	}
+
+	pending := make(map[string]int)
+	dependents := make(map[string][]string)
+	for node := range included {
+		pending[node] = 0
+		for _, dep := range g[node] {
+			if included[dep] {
+				pending[node]++
+				dependents[dep] = append(dependents[dep], node)
+			}
+		}
+	}
+
+	ready := []string{}
+	for node, count := range pending {
+		if count == 0 {
+			ready = append(ready, node)
+		}
+	}
+
+	order := make([]string, 0, len(included))
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		node := ready[0]
+		ready = ready[1:]
+		order = append(order, node)
+		for _, dependent := range dependents[node] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(order) != len(included) {
+		return nil, g.AssertAcyclic()
+	}
+	return order, nil
+}
+
+// Splits the given nodes into waves: every node only depends on nodes of
+// earlier waves, so the nodes of a single wave can be handled concurrently.
+func (g DependencyGraph) Waves(nodes []string) ([][]string, error) {
+	order, err := g.TopologicalOrder(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	levels := make(map[string]int)
+	for _, node := range order {
+		levels[node] = 0
+	}
+	waves := [][]string{}
+	for _, node := range order {
+		level := 0
+		for _, dep := range g[node] {
+			if depLevel, found := levels[dep]; found && depLevel+1 > level {
+				level = depLevel + 1
+			}
+		}
+		levels[node] = level
+		if level == len(waves) {
+			waves = append(waves, []string{})
+		}
+		waves[level] = append(waves[level], node)
+	}
+	return waves, nil
+}
+
+func (g DependencyGraph) AssertAcyclic() error {
+	cycles := g.Cycles()
+	if len(cycles) == 0 {
+		return nil
+	}
+	paths := make([]string, len(cycles))
+	for i, cycle := range cycles {
+		paths[i] = strings.Join(cycle, " -> ")
+	}
+	return fmt.Errorf("Dependency cycle detected: %s", strings.Join(paths, "; "))
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+// Returns a closed path through every node of the strongly connected
+// component, going from each node to the next one by name through the
+// shortest path within the component
+func (g DependencyGraph) walkThrough(component []string) []string {
+	sort.Strings(component)
+	inComponent := make(map[string]bool)
+	for _, node := range component {
+		inComponent[node] = true
+	}
+
+	walk := []string{component[0]}
+	visited := map[string]bool{component[0]: true}
+	for _, target := range append(component[1:], component[0]) {
+		if visited[target] && target != component[0] {
+			continue
+		}
+		path := g.shortestPath(walk[len(walk)-1], target, inComponent)
+		for _, node := range path {
+			visited[node] = true
+		}
+		walk = append(walk, path...)
+	}
+	return walk
+}
+
+// Returns the nodes after from on the shortest path to target that only goes
+// through the allowed nodes, ending with target
+func (g DependencyGraph) shortestPath(from, target string, allowed map[string]bool) []string {
+	previous := make(map[string]string)
+	queue := []string{from}
+	for i := 0; i < len(queue); i++ {
+		for _, dep := range g[queue[i]] {
+			if _, found := previous[dep]; found || !allowed[dep] {
+				continue
+			}
+			previous[dep] = queue[i]
+			if dep == target {
+				path := []string{target}
+				for node := queue[i]; node != from; node = previous[node] {
+					path = append([]string{node}, path...)
+				}
+				return path
+			}
+			queue = append(queue, dep)
+		}
+	}
+	return []string{target}
+}
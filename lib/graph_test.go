@@ -0,0 +1,62 @@
+package kubemgr
+
+import (
+	"strings"
+	"testing"
+)
+
+func cyclePaths(g DependencyGraph) []string {
+	paths := []string{}
+	for _, cycle := range g.Cycles() {
+		paths = append(paths, strings.Join(cycle, " -> "))
+	}
+	return paths
+}
+
+func TestCyclesReportsEveryNodeOfAComponent(t *testing.T) {
+	// a -> b -> c -> a, and a -> c -> a
+	g := DependencyGraph{
+		"a": {"b", "c"},
+		"b": {"c"},
+		"c": {"a"},
+	}
+	assertOrder(t, "cycles", cyclePaths(g), "a -> b -> c -> a")
+}
+
+func TestCyclesWalksThroughComponentsWithoutASingleCycle(t *testing.T) {
+	// a depends on b and c, which both depend on a: no single cycle goes
+	// through all three
+	g := DependencyGraph{
+		"a": {"b", "c"},
+		"b": {"a"},
+		"c": {"a"},
+	}
+	assertOrder(t, "cycles", cyclePaths(g), "a -> b -> a -> c -> a")
+}
+
+func TestCyclesReportsEachComponentOnce(t *testing.T) {
+	g := DependencyGraph{
+		"app":   {"db", "cache"},
+		"db":    {"db-cm"},
+		"db-cm": {"db"},
+		"cache": {"cache"},
+		"log":   {},
+	}
+	assertOrder(t, "cycles", cyclePaths(g), "cache -> cache", "db -> db-cm -> db")
+
+	err := g.AssertAcyclic()
+	if err == nil || err.Error() != "Dependency cycle detected: cache -> cache; db -> db-cm -> db" {
+		t.Errorf("Got %v", err)
+	}
+}
+
+func TestCyclesOfAnAcyclicGraph(t *testing.T) {
+	g := DependencyGraph{
+		"a": {"b", "c"},
+		"b": {"c"},
+		"c": {},
+	}
+	if cycles := g.Cycles(); len(cycles) != 0 {
+		t.Errorf("Got cycles %v in an acyclic graph", cycles)
+	}
+}
@@ -0,0 +1,144 @@
+package kubemgr
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apourchet/kubemgr/lib/kubectl"
+	"github.com/golang/glog"
+)
+
+// Hook is a local command run before or after a resource is applied or
+// deleted. The injector data is available to it as environment variables.
+type Hook struct {
+	Name    string
+	Command []string
+	Timeout string
+}
+
+const (
+	HookPreApply   = "preApply"
+	HookPostApply  = "postApply"
+	HookPreDelete  = "preDelete"
+	HookPostDelete = "postDelete"
+)
+
+var (
+	envNameRegexp = regexp.MustCompile(`[^A-Za-z0-9_]`)
+)
+
+func (h Hook) Validate() error {
+	if len(h.Command) == 0 {
+		return fmt.Errorf("Hook needs a command")
+	}
+	if h.Timeout != "" {
+		if _, err := time.ParseDuration(h.Timeout); err != nil {
+			return fmt.Errorf("Invalid hook timeout: %v", err)
+		}
+	}
+	return nil
+}
+
+func (h Hook) String() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	return strings.Join(h.Command, " ")
+}
+
+func (h Hook) Run(ctx context.Context, env []string) error {
+	prefix := kubectl.LogPrefix(ctx)
+	if kubectl.DryRun != "" {
+		glog.Infof("%sDry run: skipping hook '%s'", prefix, h)
+		return nil
+	}
+	if h.Timeout != "" {
+		timeout, _ := time.ParseDuration(h.Timeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	glog.Infof("%sRunning hook '%s'", prefix, h)
+	return runCommand(ctx, env, h.Command[0], h.Command[1:]...)
+}
+
+// Returns the hooks of the resource for that phase
+func (res Resource) Hooks(phase string) []Hook {
+	switch phase {
+	case HookPreApply:
+		return res.PreApply
+	case HookPostApply:
+		return res.PostApply
+	case HookPreDelete:
+		return res.PreDelete
+	case HookPostDelete:
+		return res.PostDelete
+	}
+	return nil
+}
+
+// Runs the hooks of the resource for that phase one after the other, stopping
+// at the first one that fails
+func (r *ResourceManager) runHooks(ctx context.Context, resourceName, phase string) error {
+	hooks := r.Resources[resourceName].Hooks(phase)
+	if len(hooks) == 0 {
+		return nil
+	}
+	env := r.hookEnv(resourceName, phase)
+	for _, hook := range hooks {
+		err := hook.Run(ctx, env)
+		if err != nil {
+			return fmt.Errorf("Hook %s '%s' of %s failed: %v", phase, hook, resourceName, err)
+		}
+	}
+	return nil
+}
+
+// Builds the environment of a hook: the current environment, the injector
+// data flattened into variables (nested keys are joined with '_') and a few
+// variables describing the hook itself
+func (r *ResourceManager) hookEnv(resourceName, phase string) []string {
+	vars := make(map[string]string)
+	flattenEnv("", r.Injector.GetData(), vars)
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	env := os.Environ()
+	for _, name := range names {
+		env = append(env, name+"="+vars[name])
+	}
+	return append(env,
+		"KUBEMGR_HOOK="+phase,
+		"KUBEMGR_RESOURCE="+resourceName,
+		"KUBEMGR_FILE="+r.Injector.GetInjectedFilePath(r.Resources[resourceName].Path),
+	)
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+func flattenEnv(prefix string, value interface{}, vars map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, inner := range v {
+			name := envNameRegexp.ReplaceAllString(key, "_")
+			if prefix != "" {
+				name = prefix + "_" + name
+			}
+			flattenEnv(name, inner, vars)
+		}
+	case string:
+		vars[prefix] = v
+	default:
+		vars[prefix] = stringify(v)
+	}
+}
@@ -0,0 +1,128 @@
+package kubemgr
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func readEvents(t *testing.T) []string {
+	content, err := ioutil.ReadFile("events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+const logHook = `{"command": ["sh", "-c", "echo $KUBEMGR_HOOK $KUBEMGR_RESOURCE >> events"]}`
+
+func TestHooksRunAroundTheApply(t *testing.T) {
+	cluster := newShop(t)
+	cluster.eventLog = "events"
+	extendShopDB(t, `"preApply": [`+logHook+`], "postApply": [`+logHook+`],
+        "preDelete": [`+logHook+`], "postDelete": [`+logHook+`],
+        "checks": [{"command": ["sh", "-c", "echo check db-dp >> events"]}]`)
+	err := loadShop(t, cluster).ApplyResources("app-dp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOrder(t, "events", readEvents(t),
+		"apply db-svc", "preApply db-dp", "apply db-dp", "check db-dp", "postApply db-dp", "apply app-dp")
+
+	err = ioutil.WriteFile("events", nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loadShop(t, cluster).DeleteResources("*-dp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOrder(t, "events", readEvents(t), "delete app-dp", "preDelete db-dp", "delete db-dp", "postDelete db-dp")
+}
+
+func TestHookEnvironment(t *testing.T) {
+	cluster := newShop(t)
+	err := ioutil.WriteFile("injects.json", []byte(`{"namespace": "shop", "db": {"host-name": "db.internal", "port": 5432}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := strings.Replace(shopConfig, `"package": "shop",`, `"package": "shop", "injects": [{"name": "mine", "path": "injects.json"}],`, 1)
+	config = strings.Replace(config, `"deps": ["db-svc"]}`, `"deps": ["db-svc"], "postApply": [{"command": ["sh", "-c", "env > env"]}]}`, 1)
+	err = ioutil.WriteFile("kubeconfig.json", []byte(config), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loadShop(t, cluster).ApplyResources("db-dp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{}
+	for _, line := range readLines(t, "env") {
+		if parts := strings.SplitN(line, "=", 2); len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	for name, want := range map[string]string{
+		"namespace":        "shop",
+		"db_host_name":     "db.internal",
+		"db_port":          "5432",
+		"KUBEMGR_HOOK":     HookPostApply,
+		"KUBEMGR_RESOURCE": "db-dp",
+	} {
+		if env[name] != want {
+			t.Errorf("Got $%s=%q, want %q", name, env[name], want)
+		}
+	}
+	if !strings.HasSuffix(env["KUBEMGR_FILE"], "k8s/db-dp.json.inj") {
+		t.Errorf("Got $KUBEMGR_FILE=%q, want the injected file of db-dp", env["KUBEMGR_FILE"])
+	}
+	// The data of each inject is also available under its name
+	found := false
+	for name, value := range env {
+		if strings.HasSuffix(name, "mine_db_host_name") && value == "db.internal" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Got environment %v, want the data of the inject under its name", env)
+	}
+}
+
+func TestFailingHookAbortsTheApply(t *testing.T) {
+	cluster := newShop(t)
+	extendShopDB(t, `"preApply": [{"name": "snapshot", "command": ["sh", "-c", "exit 3"]}]`)
+	err := loadShop(t, cluster).ApplyResources("app-dp")
+	if err == nil || !strings.Contains(err.Error(), "Hook preApply 'snapshot' of db-dp failed") {
+		t.Fatalf("Got %v, want the hook failure", err)
+	}
+	assertOrder(t, "apply order", cluster.applied, "db-svc")
+
+	// A failing post-apply hook stops the dependents
+	cluster = newShop(t)
+	extendShopDB(t, `"postApply": [{"command": ["false"]}]`)
+	err = loadShop(t, cluster).ApplyResources("app-dp")
+	if err == nil || !strings.Contains(err.Error(), "Hook postApply 'false' of db-dp failed") {
+		t.Fatalf("Got %v, want the hook failure", err)
+	}
+	assertOrder(t, "apply order", cluster.applied, "db-svc", "db-dp")
+}
+
+func TestHookValidation(t *testing.T) {
+	for _, tc := range []struct {
+		hook Hook
+		err  string
+	}{
+		{Hook{Command: []string{"true"}}, ""},
+		{Hook{Command: []string{"true"}, Timeout: "5m"}, ""},
+		{Hook{Name: "snapshot"}, "needs a command"},
+		{Hook{Command: []string{"true"}, Timeout: "soon"}, "Invalid hook timeout"},
+	} {
+		err := tc.hook.Validate()
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: got %v, want no error", tc.hook, err)
+		} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: got %v, want %q", tc.hook, err, tc.err)
+		}
+	}
+}
@@ -1,67 +1,251 @@
 package kubemgr
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"fmt"
 	"path"
-)
+	"strings"
 
-type PackagedImports struct {
-	Package string
-	Imports []Import
-}
+	"github.com/golang/glog"
+)
 
+// Import is a configuration file to import, given by its path relative to the
+// importing file or by its http(s) URL. Sha256 pins the digest of a package
+// imported from a URL. When Git is set, Path is relative to the root of that
+// repository checked out at Ref.
 type Import struct {
-	Path string
+	Path   string
+	Sha256 string
+	Git    string
+	Ref    string
 }
 
 type ImportManagerInterface interface {
 	GetImports(filepath string) ([]string, error)
 	GetImportClosure([]string) ([]string, error)
+	ResolveImport(filepath string, imp Import) (string, error)
+	CheckLock(imports []string) error
+	SaveLock(imports []string) error
 }
 
-type ImportManager struct{}
+type ImportManager struct {
+	// Sources maps the local path of every resolved import to where it comes
+	// from, against which its own relative imports are resolved
+	Sources map[string]ImportLock
+
+	// Lock is the lock file as it was read, and Resolved what the imports
+	// resolved to since
+	Lock     *Lock
+	Resolved *Lock
+}
 
 func NewImportManager() ImportManagerInterface {
-	i := ImportManager{}
+	i := ImportManager{Sources: make(map[string]ImportLock), Resolved: &Lock{}}
 	return &i
 }
 
+// Returns the local paths of the configurations imported by the file,
+// downloading the ones imported from a URL
 func (i *ImportManager) GetImports(filepath string) ([]string, error) {
-	configBytes, err := ioutil.ReadFile(filepath)
+	config, err := LoadConfig(filepath)
 	if err != nil {
 		return nil, err
 	}
-	pkg := PackagedImports{}
-	err = json.Unmarshal(configBytes, &pkg)
-	if err != nil {
-		return nil, err
-	}
-	paths := make([]string, len(pkg.Imports))
-	for i := range pkg.Imports {
-		paths[i] = pkg.Imports[i].Path
+	paths := make([]string, len(config.Imports))
+	for j := range config.Imports {
+		paths[j], err = i.ResolveImport(filepath, config.Imports[j])
+		if err != nil {
+			return nil, err
+		}
 	}
 	return paths, nil
 }
 
 func (mgr *ImportManager) GetImportClosure(imports []string) ([]string, error) {
 	for i := 0; i < len(imports); i++ {
-		imp := imports[i]
-		subImps, err := mgr.GetImports(imp)
+		subImps, err := mgr.GetImports(imports[i])
 		if err != nil {
 			return nil, err
 		}
-		prefix := path.Dir(imp)
-		newImps := pathsFromImports(prefix, subImps)
-		imports = append(imports, newImps...)
+		imports = append(imports, subImps...)
 	}
 	return imports, nil
 }
 
-func pathsFromImports(prefix string, imports []string) []string {
-	ret := make([]string, len(imports))
-	for i := range imports {
-		ret[i] = path.Join(prefix, imports[i])
+// Returns the local path of a configuration imported by the file. Relative
+// imports of a package that was itself imported from a URL or from a git
+// repository are resolved against it.
+func (i *ImportManager) ResolveImport(filepath string, imp Import) (string, error) {
+	parent := i.Sources[path.Clean(filepath)]
+	remote := imp.Git == "" && (isURL(imp.Path) || isURL(parent.Path))
+	if imp.Sha256 != "" && !remote {
+		return "", fmt.Errorf("Import %s: sha256 can only pin imports from URLs", imp.Path)
+	}
+
+	localPath := path.Join(path.Dir(filepath), imp.Path)
+	source := ImportLock{Path: localPath}
+	var err error
+	switch {
+	case imp.Git != "":
+		localPath, source, err = i.resolveGitImport(imp)
+	case remote:
+		localPath, source, err = i.resolveURLImport(parent, imp)
+	case parent.Git != "":
+		file := path.Join(path.Dir(parent.Path), imp.Path)
+		if path.IsAbs(imp.Path) || isOutside(file) {
+			return "", fmt.Errorf("Import of %s: %s is outside of the repository", parent.Git, imp.Path)
+		}
+		source = ImportLock{Path: file, Git: parent.Git, Commit: parent.Commit}
+	}
+	if err != nil {
+		return "", err
+	}
+	i.Sources[path.Clean(localPath)] = source
+	return localPath, nil
+}
+
+// Compares the import closure and the digest of every package to the lock
+// file. A lock file that is out of date is only warned about, unless --frozen
+// is set.
+func (i *ImportManager) CheckLock(imports []string) error {
+	err := i.resolveLock(imports)
+	if err != nil {
+		return err
+	}
+
+	exists, err := fileExists(LockFile)
+	if err != nil {
+		return err
+	}
+	// Local imports cannot change behind the configuration's back, they do
+	// not need a lock file
+	if !exists {
+		if !i.Resolved.isRemote() {
+			return nil
+		} else if Frozen {
+			return fmt.Errorf("No %s to check the remote imports against, run 'kubemgr deps update' first", LockFile)
+		}
+		glog.Warningf("No %s records the remote imports, run 'kubemgr deps update' to create it", LockFile)
+		return nil
+	}
+	locked, err := i.lock()
+	if err != nil {
+		return err
+	}
+	diff := locked.Diff(i.Resolved)
+	if len(diff) == 0 {
+		return nil
+	}
+	if Frozen {
+		return fmt.Errorf("Imports differ from %s:\n  %s", LockFile, strings.Join(diff, "\n  "))
+	}
+	glog.Warningf("Imports differ from %s, run 'kubemgr deps update' to update it:\n  %s", LockFile, strings.Join(diff, "\n  "))
+	return nil
+}
+
+// Records the import closure in the lock file along with the digest of every
+// package
+func (i *ImportManager) SaveLock(imports []string) error {
+	err := i.resolveLock(imports)
+	if err != nil {
+		return err
+	}
+	return i.Resolved.Save(LockFile)
+}
+
+func (i *ImportManager) resolveLock(imports []string) error {
+	seen := make(map[string]bool)
+	for _, imp := range imports {
+		imp = path.Clean(imp)
+		if seen[imp] {
+			continue
+		}
+		seen[imp] = true
+		entry, found := i.Sources[imp]
+		if !found {
+			entry = ImportLock{Path: imp}
+		}
+		digest, err := packageDigest(imp)
+		if err != nil {
+			return err
+		}
+		entry.Sha256 = digest
+		i.Resolved.Imports = append(i.Resolved.Imports, entry)
+	}
+	return nil
+}
+
+// Checks the repository out at the commit its ref is locked to, or else at the
+// one it points to now
+func (i *ImportManager) resolveGitImport(imp Import) (string, ImportLock, error) {
+	file := path.Clean(imp.Path)
+	if isURL(imp.Path) || path.IsAbs(file) || isOutside(file) {
+		return "", ImportLock{}, fmt.Errorf("Import of %s: %s is outside of the repository", imp.Git, imp.Path)
+	}
+	locked, err := i.lock()
+	if err != nil {
+		return "", ImportLock{}, err
+	}
+
+	pkg := NewGitPackage(imp.Git, imp.Ref)
+	commit := locked.GitCommit(pkg.URL, pkg.Ref)
+	if commit == "" && Frozen {
+		return "", ImportLock{}, fmt.Errorf("Import of %s at %s is not in %s", pkg.URL, pkg.Ref, LockFile)
 	}
-	return ret
+	if commit == "" {
+		commit, err = pkg.Resolve()
+		if err != nil {
+			return "", ImportLock{}, err
+		}
+	}
+	dir, err := pkg.Checkout(commit)
+	if err != nil {
+		return "", ImportLock{}, err
+	}
+	i.Resolved.AddGit(pkg.URL, pkg.Ref, commit)
+	return path.Join(dir, file), ImportLock{Path: file, Git: pkg.URL, Commit: commit}, nil
+}
+
+// Downloads the package, resolving its URL against the one of the importing
+// package when it is relative
+func (i *ImportManager) resolveURLImport(parent ImportLock, imp Import) (string, ImportLock, error) {
+	location := imp.Path
+	if isURL(parent.Path) {
+		parentPackage, err := NewRemotePackage(parent.Path)
+		if err != nil {
+			return "", ImportLock{}, err
+		}
+		location, err = parentPackage.Resolve(location)
+		if err != nil {
+			return "", ImportLock{}, err
+		}
+	}
+	pkg, err := NewRemotePackage(location)
+	if err != nil {
+		return "", ImportLock{}, err
+	}
+	err = pkg.Fetch(imp.Sha256)
+	if err != nil {
+		return "", ImportLock{}, err
+	}
+	return pkg.Config, ImportLock{Path: location}, nil
+}
+
+func (i *ImportManager) lock() (*Lock, error) {
+	if i.Lock == nil {
+		lock, err := LoadLock(LockFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s: %v", LockFile, err)
+		}
+		i.Lock = lock
+	}
+	return i.Lock, nil
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+// Whether the relative path leaves the directory it is relative to
+func isOutside(file string) bool {
+	file = path.Clean(file)
+	return file == ".." || strings.HasPrefix(file, "../")
 }
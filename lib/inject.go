@@ -21,15 +21,11 @@ type Inject struct {
 	Path string
 }
 
-type PackagedInjects struct {
-	Package string
-	Injects []Inject
-}
-
 type InjectorInterface interface {
 	GetInjects(configPaths []string) error
 	Inject(filepath string) error
 	GetInjectedFilePath(filePath string) string
+	GetData() map[string]interface{}
 	String() string
 }
 
@@ -93,6 +89,10 @@ func (i *Injector) GetInjectedFilePath(filePath string) string {
 	return filePath + ".inj"
 }
 
+func (i *Injector) GetData() map[string]interface{} {
+	return i.Data
+}
+
 func (i *Injector) doInject(content []byte) ([]byte, error) {
 	tname := fmt.Sprintf("%s", sha1.Sum(content))
 	tmpl, err := template.New(tname).Funcs(getFuncMap()).Parse(string(content))
@@ -119,21 +119,16 @@ func (i *Injector) String() string {
 func fetchInjects(configPaths []string) ([]Inject, error) {
 	injects := []Inject{}
 	for _, fpath := range configPaths {
-		configBytes, err := ioutil.ReadFile(fpath)
-		if err != nil {
-			return nil, err
-		}
-		pkg := PackagedInjects{}
-		err = json.Unmarshal(configBytes, &pkg)
+		config, err := LoadConfig(fpath)
 		if err != nil {
 			return nil, err
 		}
 		prefix := path.Dir(fpath)
-		for i := range pkg.Injects {
-			pkg.Injects[i].Name = pkg.Package + "_" + pkg.Injects[i].Name
-			pkg.Injects[i].Path = path.Join(prefix, pkg.Injects[i].Path)
+		for _, inject := range config.Injects {
+			inject.Name = config.Package + "_" + inject.Name
+			inject.Path = path.Join(prefix, inject.Path)
+			injects = append(injects, inject)
 		}
-		injects = append(injects, pkg.Injects...)
 	}
 	return injects, nil
 }
@@ -147,7 +142,7 @@ func dataFromFile(filepath string) (map[string]interface{}, error) {
 	if err != nil {
 		return data, err
 	}
-	err = json.Unmarshal(configBytes, &data)
+	err = unmarshalFile(filepath, configBytes, &data)
 	return data, err
 }
 
@@ -0,0 +1,81 @@
+package kubectl
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	BackendKubectl = "kubectl"
+	BackendFake    = "fake"
+	BackendNative  = "native"
+)
+
+// Client is how kubemgr talks to a cluster. Every method works on the objects
+// described by an injected manifest file.
+type Client interface {
+	Apply(ctx context.Context, filePath string) error
+	Get(ctx context.Context, filePath string) ([]byte, error)
+	Delete(ctx context.Context, filePath string) error
+	Wait(ctx context.Context, filePath string, readiness Readiness) error
+	Exists(ctx context.Context, filePath string) (bool, error)
+	Diff(ctx context.Context, filePath string) (string, bool, error)
+}
+
+// Watcher is implemented by the clients that can stream the changes to the
+// objects of a file, so that waiting for them does not need polling. Every
+// event holds the latest state of one or more of the objects.
+type Watcher interface {
+	Watch(ctx context.Context, filePath string) (<-chan []byte, error)
+}
+
+// Lister is implemented by the clients that can find the live objects
+// matching a label selector ("key=value,..."). List looks through every kind
+// and namespace, ListKind only through the objects of one kind in one
+// namespace, the default one when empty. DefaultNamespace is the namespace
+// that the objects which do not name one are created in.
+type Lister interface {
+	List(ctx context.Context, selector string) ([]map[string]interface{}, error)
+	ListKind(ctx context.Context, apiVersion, kind, namespace, selector string) ([]map[string]interface{}, error)
+	DefaultNamespace(ctx context.Context) (string, error)
+}
+
+var (
+	// File in which the fake backend keeps its objects between runs; the fake
+	// cluster only lives in memory when empty.
+	FakeState = ""
+)
+
+func NewClient(backend string) (Client, error) {
+	switch backend {
+	case BackendKubectl:
+		return NewKubectl(), nil
+	case BackendFake:
+		if FakeState == "" {
+			return NewFakeCluster(), nil
+		}
+		return NewFakeClusterFromFile(FakeState)
+	case BackendNative:
+		config, err := LoadRESTConfig(Context)
+		if err != nil {
+			return nil, err
+		}
+		return NewNative(config), nil
+	}
+	return nil, fmt.Errorf("Unknown backend '%s': expected '%s', '%s' or '%s'", backend, BackendKubectl, BackendFake, BackendNative)
+}
+
+type logPrefixKey struct{}
+
+// Returns a context whose kubectl log lines are prefixed, so that the output
+// of resources handled concurrently stays readable.
+func WithLogPrefix(ctx context.Context, prefix string) context.Context {
+	return context.WithValue(ctx, logPrefixKey{}, prefix)
+}
+
+func LogPrefix(ctx context.Context) string {
+	if prefix, ok := ctx.Value(logPrefixKey{}).(string); ok {
+		return prefix
+	}
+	return ""
+}
@@ -0,0 +1,246 @@
+package kubectl
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Condition is a custom readiness condition evaluated against the live
+// object, written as a JSONPath-like field path optionally compared to a JSON
+// value:
+//
+//	.status.phase == "Running"
+//	.status.conditions[?(@.type=="Ready")].status == "True"
+//	.status.readyReplicas
+//
+// A path alone is met when it points to a value that is not null, false, 0 or
+// "". The condition is met when any of the values the path selects matches.
+type Condition struct {
+	Expr  string
+	Path  []pathSegment
+	Op    string
+	Value interface{}
+}
+
+type pathSegment struct {
+	Field  string
+	Index  int
+	All    bool
+	Filter *Condition
+}
+
+const (
+	opEquals    = "=="
+	opNotEquals = "!="
+)
+
+func ParseCondition(expr string) (*Condition, error) {
+	c := Condition{Expr: expr}
+	left, right, op := splitOperator(expr)
+	left = strings.TrimSpace(left)
+	left = strings.TrimSuffix(strings.TrimPrefix(left, "{"), "}")
+
+	path, err := parsePath(left)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid condition '%s': %v", expr, err)
+	}
+	c.Path = path
+	c.Op = op
+	if op != "" {
+		right = strings.TrimSpace(right)
+		if right == "" {
+			return nil, fmt.Errorf("Invalid condition '%s': missing value after %s", expr, op)
+		}
+		err = json.Unmarshal([]byte(right), &c.Value)
+		if err != nil {
+			c.Value = right
+		}
+	}
+	return &c, nil
+}
+
+// Returns whether the object meets the condition, along with the values the
+// path selected so that a failed condition can be explained
+func (c *Condition) Eval(obj interface{}) (bool, []interface{}) {
+	values := selectPath(obj, c.Path)
+	for _, value := range values {
+		if c.matches(value) {
+			return true, values
+		}
+	}
+	return false, values
+}
+
+func (c *Condition) matches(value interface{}) bool {
+	switch c.Op {
+	case opEquals:
+		return reflect.DeepEqual(value, c.Value)
+	case opNotEquals:
+		return !reflect.DeepEqual(value, c.Value)
+	}
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+func selectPath(obj interface{}, path []pathSegment) []interface{} {
+	values := []interface{}{obj}
+	for _, segment := range path {
+		next := []interface{}{}
+		for _, value := range values {
+			next = append(next, segment.apply(value)...)
+		}
+		values = next
+	}
+	return values
+}
+
+func (s pathSegment) apply(value interface{}) []interface{} {
+	if s.Field != "" {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if field, found := m[s.Field]; found {
+			return []interface{}{field}
+		}
+		return nil
+	}
+
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	if s.All {
+		return items
+	}
+	if s.Filter != nil {
+		matching := []interface{}{}
+		for _, item := range items {
+			if met, _ := s.Filter.Eval(item); met {
+				matching = append(matching, item)
+			}
+		}
+		return matching
+	}
+	if s.Index >= 0 && s.Index < len(items) {
+		return []interface{}{items[s.Index]}
+	}
+	return nil
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+// Splits the expression around its comparison operator, ignoring the ones
+// inside of brackets or quotes
+func splitOperator(expr string) (string, string, string) {
+	depth := 0
+	quoted := false
+	for i := 0; i < len(expr)-1; i++ {
+		switch {
+		case expr[i] == '"':
+			quoted = !quoted
+		case quoted:
+		case expr[i] == '[':
+			depth++
+		case expr[i] == ']':
+			depth--
+		case depth == 0 && (expr[i:i+2] == opEquals || expr[i:i+2] == opNotEquals):
+			return expr[:i], expr[i+2:], expr[i : i+2]
+		}
+	}
+	return expr, "", ""
+}
+
+func parsePath(path string) ([]pathSegment, error) {
+	segments := []pathSegment{}
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			end := i + 1
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+			if end == i+1 {
+				return nil, fmt.Errorf("empty field name at position %d", i)
+			}
+			segments = append(segments, pathSegment{Field: path[i+1 : end]})
+			i = end
+		case '[':
+			end := matchingBracket(path, i)
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed '[' at position %d", i)
+			}
+			segment, err := parseBracket(path[i+1 : end])
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, segment)
+			i = end + 1
+		default:
+			return nil, fmt.Errorf("expected '.' or '[' at position %d", i)
+		}
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return segments, nil
+}
+
+func parseBracket(inner string) (pathSegment, error) {
+	inner = strings.TrimSpace(inner)
+	if inner == "*" {
+		return pathSegment{All: true}, nil
+	}
+	if strings.HasPrefix(inner, "?(") && strings.HasSuffix(inner, ")") {
+		expr := strings.TrimSpace(inner[2 : len(inner)-1])
+		if !strings.HasPrefix(expr, "@") {
+			return pathSegment{}, fmt.Errorf("filter '%s' must start with '@'", expr)
+		}
+		filter, err := ParseCondition(expr[1:])
+		if err != nil {
+			return pathSegment{}, err
+		}
+		return pathSegment{Filter: filter}, nil
+	}
+	if unquoted, err := strconv.Unquote(inner); err == nil {
+		return pathSegment{Field: unquoted}, nil
+	}
+	index, err := strconv.Atoi(inner)
+	if err != nil {
+		return pathSegment{}, fmt.Errorf("invalid index '%s'", inner)
+	}
+	return pathSegment{Index: index}, nil
+}
+
+func matchingBracket(path string, open int) int {
+	depth := 0
+	quoted := false
+	for i := open; i < len(path); i++ {
+		switch {
+		case path[i] == '"':
+			quoted = !quoted
+		case quoted:
+		case path[i] == '[':
+			depth++
+		case path[i] == ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
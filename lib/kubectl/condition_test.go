@@ -0,0 +1,88 @@
+package kubectl
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const conditionObject = `{
+	"metadata": {"labels": {"app.kubernetes.io/name": "db"}},
+	"status": {
+		"phase": "Running",
+		"message": "a == b",
+		"readyReplicas": 2,
+		"unavailableReplicas": 0,
+		"conditions": [
+			{"type": "Initialized", "status": "True"},
+			{"type": "Ready", "status": "False", "message": "x == y"}
+		],
+		"containerStatuses": [{"name": "app", "ready": true}, {"name": "proxy", "ready": false}]
+	}
+}`
+
+func TestConditionEval(t *testing.T) {
+	obj := map[string]interface{}{}
+	err := json.Unmarshal([]byte(conditionObject), &obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		expr string
+		want bool
+	}{
+		{`.status.phase == "Running"`, true},
+		{`.status.phase == "Pending"`, false},
+		{`.status.phase != "Failed"`, true},
+		{`.status.phase == Running`, true},
+		{`{.status.phase} == "Running"`, true},
+		{`.status.readyReplicas == 2`, true},
+		{`.status.readyReplicas`, true},
+		{`.status.unavailableReplicas`, false},
+		{`.status.missing`, false},
+		{`.status.conditions[?(@.type=="Ready")].status == "True"`, false},
+		{`.status.conditions[?(@.type == "Initialized")].status == "True"`, true},
+		{`.status.conditions[?(@.type=="Missing")].status`, false},
+		{`.metadata.labels["app.kubernetes.io/name"] == "db"`, true},
+		{`.status.message == "a == b"`, true},
+		{`.status.conditions[?(@.message=="x == y")].type == "Ready"`, true},
+		{`.status.containerStatuses[0].ready == true`, true},
+		{`.status.containerStatuses[1].ready`, false},
+		{`.status.containerStatuses[5].ready`, false},
+		{`.status.containerStatuses[*].ready == false`, true},
+		{`.status.phase[0]`, false},
+	} {
+		condition, err := ParseCondition(tc.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		if met, values := condition.Eval(obj); met != tc.want {
+			t.Errorf("%s: got %v on %v, want %v", tc.expr, met, values, tc.want)
+		}
+	}
+}
+
+func TestConditionParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		err  string
+	}{
+		{``, "empty path"},
+		{`status.phase`, "expected '.' or '['"},
+		{`.status.`, "empty field name"},
+		{`.status..phase`, "empty field name"},
+		{`.status.conditions[0`, "unclosed '['"},
+		{`.status.conditions[?(@.type=="Ready")`, "unclosed '['"},
+		{`.status.conditions[first]`, "invalid index"},
+		{`.status.conditions[?(.type=="Ready")]`, "must start with '@'"},
+		{`.status.conditions[?(@type=="Ready")]`, "expected '.' or '['"},
+		{`.status.phase ==`, "missing value after =="},
+		{`.status.phase != `, "missing value after !="},
+	} {
+		_, err := ParseCondition(tc.expr)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: got %v, want %q", tc.expr, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package kubectl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// FakeCluster is an in-memory Client. Applied objects are stored as they are
+// and reported ready straight away, which makes it usable in tests and for
+// trying out a configuration without a cluster. When StatePath is set, the
+// objects are also saved to that file so that they survive between runs.
+type FakeCluster struct {
+	Objects   map[string]map[string]interface{}
+	StatePath string
+
+	mutex sync.Mutex
+}
+
+func NewFakeCluster() *FakeCluster {
+	f := FakeCluster{}
+	f.Objects = make(map[string]map[string]interface{})
+	return &f
+}
+
+// Returns a fake cluster backed by the state file, which is created on the
+// first change if it does not exist yet
+func NewFakeClusterFromFile(statePath string) (*FakeCluster, error) {
+	f := NewFakeCluster()
+	f.StatePath = statePath
+	content, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return f, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &f.Objects)
+	return f, err
+}
+
+func (f *FakeCluster) Apply(ctx context.Context, filePath string) error {
+	prefix := LogPrefix(ctx)
+	objects, err := ReadObjects(filePath)
+	if err != nil {
+		glog.Errorf("%sFake cluster failed applying '%s': %v", prefix, filePath, err)
+		return err
+	}
+	if DryRun != "" {
+		glog.Infof("%sDry run: fake cluster would apply '%s'", prefix, filePath)
+		return nil
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, obj := range objects {
+		markReady(obj)
+		f.Objects[ObjectKey(obj)] = obj
+	}
+	err = f.save()
+	if err != nil {
+		return err
+	}
+	glog.Infof("%sFake cluster successfully applied '%s'", prefix, filePath)
+	return nil
+}
+
+func (f *FakeCluster) Get(ctx context.Context, filePath string) ([]byte, error) {
+	objects, err := ReadObjects(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	live := make([]interface{}, len(objects))
+	for i, obj := range objects {
+		key := ObjectKey(obj)
+		found, ok := f.Objects[key]
+		if !ok {
+			return nil, fmt.Errorf("%s not found", key)
+		}
+		live[i] = found
+	}
+
+	if len(live) == 1 {
+		return json.Marshal(live[0])
+	}
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      live,
+	})
+}
+
+func (f *FakeCluster) Delete(ctx context.Context, filePath string) error {
+	prefix := LogPrefix(ctx)
+	objects, err := ReadObjects(filePath)
+	if err != nil {
+		return err
+	}
+	if DryRun != "" {
+		glog.Infof("%sDry run: fake cluster would delete '%s'", prefix, filePath)
+		return nil
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, obj := range objects {
+		key := ObjectKey(obj)
+		if _, found := f.Objects[key]; !found {
+			return fmt.Errorf("%s not found", key)
+		}
+		delete(f.Objects, key)
+	}
+	err = f.save()
+	if err != nil {
+		return err
+	}
+	glog.Infof("%sFake cluster successfully deleted '%s'", prefix, filePath)
+	return nil
+}
+
+func (f *FakeCluster) Wait(ctx context.Context, filePath string, readiness Readiness) error {
+	if DryRun != "" {
+		glog.Infof("%sDry run: skipping check of '%s'", LogPrefix(ctx), filePath)
+		return nil
+	}
+	return WaitUntilReady(ctx, f, filePath, readiness)
+}
+
+func (f *FakeCluster) Exists(ctx context.Context, filePath string) (bool, error) {
+	objects, err := ReadObjects(filePath)
+	if err != nil {
+		return false, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, obj := range objects {
+		if _, found := f.Objects[ObjectKey(obj)]; found {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *FakeCluster) List(ctx context.Context, selector string) ([]map[string]interface{}, error) {
+	wanted, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	objects := []map[string]interface{}{}
+	for _, obj := range f.Objects {
+		if hasLabels(obj, wanted) {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+func (f *FakeCluster) ListKind(ctx context.Context, apiVersion, kind, namespace, selector string) ([]map[string]interface{}, error) {
+	wanted, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		namespace, _ = f.DefaultNamespace(ctx)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	objects := []map[string]interface{}{}
+	prefix := kind + "/" + namespace + "/"
+	for key, obj := range f.Objects {
+		if strings.HasPrefix(key, prefix) && obj["apiVersion"] == apiVersion && hasLabels(obj, wanted) {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+// The fake cluster keeps every object without a namespace in "default"
+func (f *FakeCluster) DefaultNamespace(ctx context.Context) (string, error) {
+	return "default", nil
+}
+
+func (f *FakeCluster) Diff(ctx context.Context, filePath string) (string, bool, error) {
+	objects, err := ReadObjects(filePath)
+	if err != nil {
+		return "", false, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	diff := ""
+	for _, obj := range objects {
+		key := ObjectKey(obj)
+		live := map[string]interface{}{}
+		if found, ok := f.Objects[key]; ok {
+			live = withoutStatus(found)
+		}
+		desired := withoutStatus(obj)
+		if reflect.DeepEqual(live, desired) {
+			continue
+		}
+		diff += fmt.Sprintf("--- live/%s\n+++ desired/%s\n", key, key)
+		diff += diffLines(toLines(live), toLines(desired))
+	}
+	return diff, diff != "", nil
+}
+
+func (f *FakeCluster) save() error {
+	if f.StatePath == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(f.Objects, "", "   ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(f.StatePath, content, 0644)
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+// Parses a label selector made of "key=value" requirements only
+func parseSelector(selector string) (map[string]string, error) {
+	wanted := make(map[string]string)
+	for _, requirement := range strings.Split(selector, ",") {
+		parts := strings.SplitN(requirement, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Unsupported label selector '%s'", selector)
+		}
+		wanted[parts[0]] = parts[1]
+	}
+	return wanted, nil
+}
+
+func hasLabels(obj map[string]interface{}, wanted map[string]string) bool {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	labels, _ := metadata["labels"].(map[string]interface{})
+	for k, v := range wanted {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Fills in the status that a healthy cluster would eventually report
+func markReady(obj map[string]interface{}) {
+	spec, _ := obj["spec"].(map[string]interface{})
+	if spec == nil {
+		spec = make(map[string]interface{})
+	}
+	status, _ := obj["status"].(map[string]interface{})
+	if status == nil {
+		status = make(map[string]interface{})
+	}
+
+	replicas, found := spec["replicas"]
+	if !found {
+		replicas = float64(1)
+	}
+	loadBalancer := map[string]interface{}{
+		"ingress": []interface{}{map[string]interface{}{"ip": "127.0.0.1"}},
+	}
+
+	switch obj["kind"] {
+	case "Deployment":
+		spec["replicas"] = replicas
+		obj["spec"] = spec
+		status["availableReplicas"] = replicas
+		status["updatedReplicas"] = replicas
+	case "StatefulSet":
+		status["readyReplicas"] = replicas
+		status["currentRevision"] = "fake"
+		status["updateRevision"] = "fake"
+	case "DaemonSet":
+		status["desiredNumberScheduled"] = float64(1)
+		status["updatedNumberScheduled"] = float64(1)
+		status["numberReady"] = float64(1)
+	case "Job":
+		status["conditions"] = []interface{}{map[string]interface{}{"type": "Complete", "status": "True"}}
+	case "PersistentVolumeClaim":
+		status["phase"] = "Bound"
+	case "Pod":
+		status["phase"] = "Running"
+		status["conditions"] = []interface{}{map[string]interface{}{"type": "Ready", "status": "True"}}
+	case "Service", "Ingress":
+		status["loadBalancer"] = loadBalancer
+	}
+	obj["status"] = status
+}
+
+func withoutStatus(obj map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for k, v := range obj {
+		if k != "status" {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+func toLines(obj map[string]interface{}) []string {
+	content, _ := json.MarshalIndent(obj, "", "  ")
+	return strings.Split(string(content), "\n")
+}
+
+// Returns a line-based diff of the two texts, computed from their longest
+// common subsequence
+func diffLines(a, b []string) string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	diff := ""
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			diff += " " + a[i] + "\n"
+			i++
+			j++
+		} else if j >= len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]) {
+			diff += "-" + a[i] + "\n"
+			i++
+		} else {
+			diff += "+" + b[j] + "\n"
+			j++
+		}
+	}
+	return diff
+}
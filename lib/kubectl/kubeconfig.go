@@ -0,0 +1,289 @@
+package kubectl
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// RESTConfig holds what the native backend needs to reach an API server
+type RESTConfig struct {
+	Server    string
+	Namespace string
+	Token     string
+	Username  string
+	Password  string
+
+	HTTPClient *http.Client
+}
+
+type kubeConfig struct {
+	CurrentContext string `json:"current-context"`
+	Clusters       []kubeCluster
+	Contexts       []kubeContext
+	Users          []kubeUser
+}
+
+type kubeCluster struct {
+	Name    string
+	Cluster struct {
+		Server                   string
+		CertificateAuthority     string `json:"certificate-authority"`
+		CertificateAuthorityData string `json:"certificate-authority-data"`
+		InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify"`
+	}
+}
+
+type kubeContext struct {
+	Name    string
+	Context struct {
+		Cluster   string
+		User      string
+		Namespace string
+	}
+}
+
+type kubeUser struct {
+	Name string
+	User struct {
+		Token                 string
+		TokenFile             string `json:"tokenFile"`
+		Username              string
+		Password              string
+		ClientCertificate     string `json:"client-certificate"`
+		ClientCertificateData string `json:"client-certificate-data"`
+		ClientKey             string `json:"client-key"`
+		ClientKeyData         string `json:"client-key-data"`
+		Exec                  interface{}
+		AuthProvider          interface{} `json:"auth-provider"`
+	}
+}
+
+// Loads the REST configuration of the given context from the kubeconfig files
+// that kubectl would use ($KUBECONFIG or ~/.kube/config). An empty context
+// name selects the kubeconfig's current context.
+func LoadRESTConfig(contextName string) (RESTConfig, error) {
+	configPaths := []string{path.Join(os.Getenv("HOME"), ".kube", "config")}
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		configPaths = filepath.SplitList(env)
+	}
+	return LoadRESTConfigFromFiles(configPaths, contextName)
+}
+
+func LoadRESTConfigFromFile(configPath, contextName string) (RESTConfig, error) {
+	return LoadRESTConfigFromFiles([]string{configPath}, contextName)
+}
+
+// Loads the REST configuration of the context from the kubeconfig files,
+// merged the way kubectl merges the entries of $KUBECONFIG
+func LoadRESTConfigFromFiles(configPaths []string, contextName string) (RESTConfig, error) {
+	config := RESTConfig{}
+	kc, err := loadKubeConfigs(configPaths)
+	if err != nil {
+		return config, err
+	}
+	configPath := strings.Join(configPaths, string(filepath.ListSeparator))
+	if contextName == "" {
+		contextName = kc.CurrentContext
+	}
+
+	found := false
+	clusterName, userName := "", ""
+	for _, c := range kc.Contexts {
+		if c.Name == contextName {
+			found = true
+			clusterName, userName = c.Context.Cluster, c.Context.User
+			config.Namespace = c.Context.Namespace
+		}
+	}
+	if !found {
+		return config, fmt.Errorf("Context '%s' not found in kubeconfig '%s'", contextName, configPath)
+	}
+
+	tlsConfig := &tls.Config{}
+	found = false
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		config.Server = strings.TrimSuffix(c.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		ca, err := fileOrData(c.Cluster.CertificateAuthority, c.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return config, err
+		}
+		if ca != nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			tlsConfig.RootCAs.AppendCertsFromPEM(ca)
+		}
+	}
+	if !found {
+		return config, fmt.Errorf("Cluster '%s' not found in kubeconfig '%s'", clusterName, configPath)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		if u.User.Exec != nil || u.User.AuthProvider != nil {
+			return config, fmt.Errorf("User '%s' of kubeconfig '%s' gets its credentials from an exec or auth-provider plugin, which the native backend does not support: use --backend=kubectl", userName, configPath)
+		}
+		config.Token = u.User.Token
+		config.Username = u.User.Username
+		config.Password = u.User.Password
+		if u.User.TokenFile != "" {
+			token, err := ioutil.ReadFile(u.User.TokenFile)
+			if err != nil {
+				return config, err
+			}
+			config.Token = strings.TrimSpace(string(token))
+		}
+
+		cert, err := fileOrData(u.User.ClientCertificate, u.User.ClientCertificateData)
+		if err != nil {
+			return config, err
+		}
+		key, err := fileOrData(u.User.ClientKey, u.User.ClientKeyData)
+		if err != nil {
+			return config, err
+		}
+		if (cert == nil) != (key == nil) {
+			return config, fmt.Errorf("User '%s' of kubeconfig '%s' needs both a client certificate and a client key", userName, configPath)
+		}
+		if cert != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return config, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	config.HTTPClient = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	return config, nil
+}
+
+// Adds the entries of the other kubeconfig that this one does not define yet,
+// so that the first file to define a name wins
+func (kc *kubeConfig) merge(other *kubeConfig) {
+	if kc.CurrentContext == "" {
+		kc.CurrentContext = other.CurrentContext
+	}
+	for _, c := range other.Clusters {
+		if !kc.hasCluster(c.Name) {
+			kc.Clusters = append(kc.Clusters, c)
+		}
+	}
+	for _, c := range other.Contexts {
+		if !kc.hasContext(c.Name) {
+			kc.Contexts = append(kc.Contexts, c)
+		}
+	}
+	for _, u := range other.Users {
+		if !kc.hasUser(u.Name) {
+			kc.Users = append(kc.Users, u)
+		}
+	}
+}
+
+func (kc *kubeConfig) hasCluster(name string) bool {
+	for _, c := range kc.Clusters {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (kc *kubeConfig) hasContext(name string) bool {
+	for _, c := range kc.Contexts {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (kc *kubeConfig) hasUser(name string) bool {
+	for _, u := range kc.Users {
+		if u.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Makes the files that the kubeconfig refers to relative to its directory
+func (kc *kubeConfig) resolvePaths(baseDir string) {
+	for i := range kc.Clusters {
+		cluster := &kc.Clusters[i].Cluster
+		cluster.CertificateAuthority = resolvePath(baseDir, cluster.CertificateAuthority)
+	}
+	for i := range kc.Users {
+		user := &kc.Users[i].User
+		user.TokenFile = resolvePath(baseDir, user.TokenFile)
+		user.ClientCertificate = resolvePath(baseDir, user.ClientCertificate)
+		user.ClientKey = resolvePath(baseDir, user.ClientKey)
+	}
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+// Reads and merges the kubeconfig files. Relative paths are resolved against
+// the file they are written in, and missing files are skipped like kubectl
+// does, as long as one of them exists.
+func loadKubeConfigs(configPaths []string) (*kubeConfig, error) {
+	merged := kubeConfig{}
+	found := false
+	for _, configPath := range configPaths {
+		if configPath == "" {
+			continue
+		}
+		content, err := ioutil.ReadFile(configPath)
+		if os.IsNotExist(err) && len(configPaths) > 1 {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		found = true
+		kc := kubeConfig{}
+		err = yaml.Unmarshal(content, &kc)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse kubeconfig '%s': %v", configPath, err)
+		}
+		kc.resolvePaths(path.Dir(configPath))
+		merged.merge(&kc)
+	}
+	if !found {
+		return nil, fmt.Errorf("None of the kubeconfig files '%s' exists", strings.Join(configPaths, string(filepath.ListSeparator)))
+	}
+	return &merged, nil
+}
+
+func fileOrData(filePath, data string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if filePath != "" {
+		return ioutil.ReadFile(filePath)
+	}
+	return nil, nil
+}
+
+func resolvePath(baseDir, filePath string) string {
+	if filePath == "" || path.IsAbs(filePath) {
+		return filePath
+	}
+	return path.Join(baseDir, filePath)
+}
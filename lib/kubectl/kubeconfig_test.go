@@ -0,0 +1,148 @@
+package kubectl
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKubeconfig = `
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443/
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: dev-user
+    namespace: team
+- name: gke
+  context:
+    cluster: dev
+    user: gke-user
+- name: eks
+  context:
+    cluster: dev
+    user: eks-user
+users:
+- name: dev-user
+  user:
+    tokenFile: token
+- name: gke-user
+  user:
+    auth-provider:
+      name: gcp
+- name: eks-user
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: aws
+`
+
+func writeKubeconfig(t *testing.T) string {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(path.Join(dir, "token"), []byte("s3cr3t\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := path.Join(dir, "config")
+	err = ioutil.WriteFile(configPath, []byte(testKubeconfig), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return configPath
+}
+
+func TestLoadRESTConfigFromFile(t *testing.T) {
+	config, err := LoadRESTConfigFromFile(writeKubeconfig(t), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Server != "https://dev.example.com:6443" || config.Namespace != "team" || config.Token != "s3cr3t" {
+		t.Errorf("Got server %s, namespace %s, token %s", config.Server, config.Namespace, config.Token)
+	}
+}
+
+func TestLoadRESTConfigRejectsPluginCredentials(t *testing.T) {
+	configPath := writeKubeconfig(t)
+	for _, contextName := range []string{"gke", "eks"} {
+		_, err := LoadRESTConfigFromFile(configPath, contextName)
+		if err == nil || !strings.Contains(err.Error(), "--backend=kubectl") {
+			t.Errorf("%s: got %v, want an error", contextName, err)
+		}
+	}
+}
+
+// Overrides the dev cluster and adds a prod context, without a token file of
+// its own
+const prodKubeconfig = `
+current-context: prod
+clusters:
+- name: dev
+  cluster:
+    server: https://overridden.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+contexts:
+- name: prod
+  context:
+    cluster: prod
+    user: prod-user
+    namespace: ops
+users:
+- name: prod-user
+  user:
+    token: pr0d
+`
+
+func TestLoadRESTConfigMergesKubeconfigs(t *testing.T) {
+	devPath := writeKubeconfig(t)
+	prodPath := path.Join(t.TempDir(), "config")
+	err := ioutil.WriteFile(prodPath, []byte(prodKubeconfig), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := path.Join(t.TempDir(), "missing")
+
+	// The first file that defines a name or the current context wins, and
+	// relative paths stay relative to the file that holds them
+	t.Setenv("KUBECONFIG", strings.Join([]string{missing, devPath, prodPath}, string(filepath.ListSeparator)))
+	config, err := LoadRESTConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Server != "https://dev.example.com:6443" || config.Namespace != "team" || config.Token != "s3cr3t" {
+		t.Errorf("Got server %s, namespace %s, token %s, want the dev context", config.Server, config.Namespace, config.Token)
+	}
+	config, err = LoadRESTConfig("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Server != "https://prod.example.com" || config.Namespace != "ops" || config.Token != "pr0d" {
+		t.Errorf("Got server %s, namespace %s, token %s, want the prod context", config.Server, config.Namespace, config.Token)
+	}
+
+	t.Setenv("KUBECONFIG", strings.Join([]string{prodPath, devPath}, string(filepath.ListSeparator)))
+	config, err = LoadRESTConfig("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Server != "https://overridden.example.com" || config.Token != "s3cr3t" {
+		t.Errorf("Got server %s, token %s, want the dev context on the overridden cluster", config.Server, config.Token)
+	}
+	config, err = LoadRESTConfig("")
+	if err != nil || config.Namespace != "ops" {
+		t.Errorf("Got namespace %s, %v, want the current context of the first file", config.Namespace, err)
+	}
+
+	t.Setenv("KUBECONFIG", missing)
+	_, err = LoadRESTConfig("")
+	if err == nil {
+		t.Error("Missing kubeconfig was loaded")
+	}
+}
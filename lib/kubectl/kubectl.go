@@ -1,127 +1,253 @@
 package kubectl
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os/exec"
-	"time"
+	"strings"
 
 	"github.com/golang/glog"
-	flag "github.com/ogier/pflag"
 )
 
-type Resource struct {
-	Kind   string
-	Spec   map[string]interface{}
-	Status map[string]interface{}
-}
-
 const (
-	CheckSleep = 2000 * time.Millisecond
+	DryRunClient = "client"
+	DryRunServer = "server"
+	DryRunNone   = "none"
 )
 
 var (
-	CheckRetries = 20
-	Context      = ""
+	Context = ""
+
+	// When set, nothing is changed in the cluster: the commands are sent with
+	// kubectl's own --dry-run for "client" and "server", and only recorded for
+	// "none".
+	DryRun = ""
 )
 
 func init() {
 	flag.StringVar(&Context, "context", "", "Kubectl context")
-	flag.IntVar(&CheckRetries, "retries", 20, "Number of times to retry the check")
 }
 
-func Apply(filePath string) error {
-	glog.V(2).Infof("Kubectl applying '%s'", filePath)
+// Kubectl is the Client that shells out to the kubectl binary
+type Kubectl struct{}
+
+func NewKubectl() Client {
+	k := Kubectl{}
+	return &k
+}
+
+// Applies the file, killing the kubectl process if the context is cancelled
+func (k *Kubectl) Apply(ctx context.Context, filePath string) error {
+	prefix := LogPrefix(ctx)
+	glog.V(2).Infof("%sKubectl applying '%s'", prefix, filePath)
 
 	content, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		glog.Errorf("Kubectl failed applying '%s': %v", filePath, err)
+		glog.Errorf("%sKubectl failed applying '%s': %v", prefix, filePath, err)
 		return err
 	}
-	glog.V(3).Infof("Kubectl applying content: \n%s", string(content))
+	glog.V(3).Infof("%sKubectl applying content: \n%s", prefix, string(content))
 
 	args := append([]string{"apply", "-f", filePath}, ContextArgs()...)
-	cmd := exec.Command("kubectl", args...)
+	args = append(args, DryRunArgs()...)
+	if DryRun != "" {
+		glog.Infof("%sDry run: kubectl %s\n%s", prefix, strings.Join(args, " "), string(content))
+	}
+	if DryRun == DryRunNone {
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		glog.Errorf("Kubectl failed applying '%s': %v", filePath, err)
-		glog.Errorf("=> %s", out)
+		glog.Errorf("%sKubectl failed applying '%s': %v", prefix, filePath, err)
+		glog.Errorf("%s=> %s", prefix, out)
 		return err
 	}
 
-	glog.Infof("Kubectl successfully applied '%s' \n=> %s", filePath, string(out))
+	glog.Infof("%sKubectl successfully applied '%s' \n=> %s", prefix, filePath, string(out))
 	return nil
 }
 
-func Check(filePath string) error {
-	glog.Infof("Kubectl checking '%s'", filePath)
-
-	var err error
-	var out []byte
-	for i := 0; i < CheckRetries; i++ {
-		args := append([]string{"get", "-o", "json", "-f", filePath}, ContextArgs()...)
-		out, err = exec.Command("kubectl", args...).Output()
-		if err != nil {
-			time.Sleep(CheckSleep)
-			continue
-		}
+func (k *Kubectl) Get(ctx context.Context, filePath string) ([]byte, error) {
+	args := append([]string{"get", "-o", "json", "-f", filePath}, ContextArgs()...)
+	return exec.CommandContext(ctx, "kubectl", args...).Output()
+}
 
-		res := Resource{}
-		err = json.Unmarshal(out, &res)
-		if err != nil {
-			time.Sleep(CheckSleep)
-			continue
-		}
+// Streams the changes to the objects of the file, parsing the output of
+// kubectl get --watch as it comes
+func (k *Kubectl) Watch(ctx context.Context, filePath string) (<-chan []byte, error) {
+	args := append([]string{"get", "--watch", "-o", "json", "-f", filePath}, ContextArgs()...)
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
 
-		err = res.check()
-		if err != nil {
-			time.Sleep(CheckSleep)
-			continue
+	events := make(chan []byte)
+	go func() {
+		defer close(events)
+		defer cmd.Wait()
+		decoder := json.NewDecoder(stdout)
+		for {
+			var event json.RawMessage
+			if err := decoder.Decode(&event); err != nil {
+				return
+			}
+			select {
+			case events <- []byte(event):
+			case <-ctx.Done():
+				return
+			}
 		}
+	}()
+	return events, nil
+}
+
+// Waits for the objects of the file to be ready, giving up early if the
+// context is cancelled
+func (k *Kubectl) Wait(ctx context.Context, filePath string, readiness Readiness) error {
+	if DryRun != "" {
+		glog.Infof("%sDry run: skipping check of '%s'", LogPrefix(ctx), filePath)
+		return nil
 	}
+	return WaitUntilReady(ctx, k, filePath, readiness)
+}
+
+func (k *Kubectl) Delete(ctx context.Context, filePath string) error {
+	prefix := LogPrefix(ctx)
+	glog.V(2).Infof("%sKubectl deleting '%s'", prefix, filePath)
 
+	args := append([]string{"delete", "-f", filePath}, ContextArgs()...)
+	args = append(args, DryRunArgs()...)
+	if DryRun != "" {
+		glog.Infof("%sDry run: kubectl %s", prefix, strings.Join(args, " "))
+	}
+	if DryRun == DryRunNone {
+		return nil
+	}
+
+	out, err := exec.CommandContext(ctx, "kubectl", args...).Output()
 	if err != nil {
-		glog.Errorf("Kubectl failed checking '%s'", filePath)
+		glog.Errorf("%sKubectl failed deleting '%s'", prefix, filePath)
 		return err
 	}
 
-	glog.Infof("Kubectl successfully checked '%s'", filePath)
+	glog.Infof("%sKubectl successfully deleted '%s' \n=> %s", prefix, filePath, string(out))
 	return nil
 }
 
-func Delete(filePath string) error {
-	glog.V(2).Infof("Kubectl deleting '%s'", filePath)
+// Diffs the file against the live objects in the cluster, returning the
+// unified diff and whether there is any drift.
+func (k *Kubectl) Diff(ctx context.Context, filePath string) (string, bool, error) {
+	glog.V(2).Infof("Kubectl diffing '%s'", filePath)
+	if DryRun == DryRunNone {
+		glog.Infof("Dry run: kubectl diff -f %s", filePath)
+		return "", false, nil
+	}
 
-	args := append([]string{"delete", "-f", filePath}, ContextArgs()...)
-	out, err := exec.Command("kubectl", args...).Output()
+	args := append([]string{"diff", "-f", filePath}, ContextArgs()...)
+	out, err := exec.CommandContext(ctx, "kubectl", args...).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return string(out), true, nil
+	}
 	if err != nil {
-		glog.Errorf("Kubectl failed deleting '%s'", filePath)
-		return err
+		glog.Errorf("Kubectl failed diffing '%s': %v", filePath, err)
+		return "", false, err
 	}
+	return string(out), false, nil
+}
 
-	glog.Infof("Kubectl successfully deleted '%s' \n=> %s", filePath, string(out))
-	return nil
+// Returns whether the objects described by the file are present in the cluster
+func (k *Kubectl) Exists(ctx context.Context, filePath string) (bool, error) {
+	glog.V(2).Infof("Kubectl looking up '%s'", filePath)
+	if DryRun == DryRunNone {
+		glog.Infof("Dry run: assuming '%s' is not in the cluster", filePath)
+		return false, nil
+	}
+
+	args := append([]string{"get", "-o", "name", "--ignore-not-found", "-f", filePath}, ContextArgs()...)
+	out, err := exec.CommandContext(ctx, "kubectl", args...).Output()
+	if err != nil {
+		glog.Errorf("Kubectl failed looking up '%s'", filePath)
+		return false, err
+	}
+	return len(strings.TrimSpace(string(out))) > 0, nil
 }
 
-func (r Resource) check() error {
-	switch r.Kind {
-	case "Service":
-		return nil
-	case "Deployment":
-		want := int(r.Spec["replicas"].(float64))
-		haveIf := r.Status["availableReplicas"]
-		have := 0
-		if haveIf != nil {
-			have = int(haveIf.(float64))
-		}
-		if want == have {
-			return nil
-		}
-		glog.Infof("Want/have %d/%d => waiting and retrying", want, have)
-		return fmt.Errorf("Deployment not ready: want %d replicas, has %d.", want, have)
+// Lists the matching objects of every kind that can be listed and deleted
+func (k *Kubectl) List(ctx context.Context, selector string) ([]map[string]interface{}, error) {
+	glog.V(2).Infof("Kubectl listing objects matching '%s'", selector)
+	if DryRun == DryRunNone {
+		glog.Infof("Dry run: assuming no object matches '%s'", selector)
+		return []map[string]interface{}{}, nil
 	}
-	return nil
+
+	args := append([]string{"api-resources", "--verbs=list,delete", "-o", "name"}, ContextArgs()...)
+	out, err := exec.CommandContext(ctx, "kubectl", args...).Output()
+	if err != nil {
+		glog.Errorf("Kubectl failed listing the API resources")
+		return nil, err
+	}
+	kinds := strings.Fields(string(out))
+	if len(kinds) == 0 {
+		return []map[string]interface{}{}, nil
+	}
+
+	args = []string{"get", strings.Join(kinds, ","), "--all-namespaces", "-l", selector, "-o", "json"}
+	args = append(args, ContextArgs()...)
+	out, err = exec.CommandContext(ctx, "kubectl", args...).Output()
+	if err != nil {
+		glog.Errorf("Kubectl failed listing objects matching '%s'", selector)
+		return nil, err
+	}
+	return DecodeObjects(out)
+}
+
+func (k *Kubectl) ListKind(ctx context.Context, apiVersion, kind, namespace, selector string) ([]map[string]interface{}, error) {
+	glog.V(2).Infof("Kubectl listing %s objects matching '%s'", kind, selector)
+	if DryRun == DryRunNone {
+		glog.Infof("Dry run: assuming no %s matches '%s'", kind, selector)
+		return []map[string]interface{}{}, nil
+	}
+
+	// Kinds of a group are given as Kind.version.group
+	resource := kind
+	if parts := strings.SplitN(apiVersion, "/", 2); len(parts) == 2 {
+		resource = kind + "." + parts[1] + "." + parts[0]
+	}
+	args := []string{"get", resource, "-l", selector, "-o", "json"}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	args = append(args, ContextArgs()...)
+	out, err := exec.CommandContext(ctx, "kubectl", args...).Output()
+	if err != nil {
+		glog.Errorf("Kubectl failed listing %s objects matching '%s'", kind, selector)
+		return nil, err
+	}
+	return DecodeObjects(out)
+}
+
+// Returns the namespace of the kubectl context, "default" when it has none
+func (k *Kubectl) DefaultNamespace(ctx context.Context) (string, error) {
+	args := append([]string{"config", "view", "--minify", "-o", "jsonpath={..namespace}"}, ContextArgs()...)
+	out, err := exec.CommandContext(ctx, "kubectl", args...).Output()
+	if err != nil {
+		glog.Errorf("Kubectl failed reading the namespace of the context")
+		return "", err
+	}
+	if namespace := strings.TrimSpace(string(out)); namespace != "" {
+		return namespace, nil
+	}
+	return "default", nil
 }
 
 func ContextArgs() []string {
@@ -130,3 +256,18 @@ func ContextArgs() []string {
 	}
 	return []string{"--context", Context}
 }
+
+func DryRunArgs() []string {
+	if DryRun == DryRunClient || DryRun == DryRunServer {
+		return []string{"--dry-run=" + DryRun}
+	}
+	return []string{}
+}
+
+func CheckDryRun(mode string) error {
+	switch mode {
+	case "", DryRunClient, DryRunServer, DryRunNone:
+		return nil
+	}
+	return fmt.Errorf("Unknown dry-run mode '%s': expected '%s', '%s' or '%s'", mode, DryRunClient, DryRunServer, DryRunNone)
+}
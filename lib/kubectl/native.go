@@ -0,0 +1,592 @@
+package kubectl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+const (
+	FieldManager = "kubemgr"
+)
+
+// Native is the Client that talks to the API server directly over HTTP,
+// applying objects with server-side apply instead of shelling out to kubectl.
+type Native struct {
+	Config RESTConfig
+
+	mutex     sync.Mutex
+	discovery map[string][]apiResource
+}
+
+type apiResource struct {
+	Name       string
+	Kind       string
+	Namespaced bool
+	Verbs      []string
+}
+
+type statusError struct {
+	Code    int
+	Message string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("API server returned %d: %s", e.Code, e.Message)
+}
+
+func NewNative(config RESTConfig) *Native {
+	n := Native{}
+	n.Config = config
+	if n.Config.HTTPClient == nil {
+		n.Config.HTTPClient = http.DefaultClient
+	}
+	n.discovery = make(map[string][]apiResource)
+	return &n
+}
+
+func (n *Native) Apply(ctx context.Context, filePath string) error {
+	prefix := LogPrefix(ctx)
+	glog.V(2).Infof("%sNative applying '%s'", prefix, filePath)
+	objects, err := ReadObjects(filePath)
+	if err != nil {
+		glog.Errorf("%sNative failed applying '%s': %v", prefix, filePath, err)
+		return err
+	}
+	if DryRun == DryRunClient || DryRun == DryRunNone {
+		content, _ := json.MarshalIndent(objects, "", "   ")
+		glog.Infof("%sDry run: native would apply '%s'\n%s", prefix, filePath, string(content))
+		return nil
+	}
+
+	for _, obj := range objects {
+		_, err = n.apply(ctx, obj, DryRun == DryRunServer)
+		if err != nil {
+			glog.Errorf("%sNative failed applying '%s': %v", prefix, filePath, err)
+			return err
+		}
+	}
+	glog.Infof("%sNative successfully applied '%s'", prefix, filePath)
+	return nil
+}
+
+func (n *Native) Get(ctx context.Context, filePath string) ([]byte, error) {
+	objects, err := ReadObjects(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	live := make([]interface{}, len(objects))
+	for i, obj := range objects {
+		live[i], err = n.get(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(live) == 1 {
+		return json.Marshal(live[0])
+	}
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      live,
+	})
+}
+
+func (n *Native) Delete(ctx context.Context, filePath string) error {
+	prefix := LogPrefix(ctx)
+	glog.V(2).Infof("%sNative deleting '%s'", prefix, filePath)
+	objects, err := ReadObjects(filePath)
+	if err != nil {
+		return err
+	}
+	if DryRun == DryRunClient || DryRun == DryRunNone {
+		glog.Infof("%sDry run: native would delete '%s'", prefix, filePath)
+		return nil
+	}
+
+	query := url.Values{}
+	query.Set("propagationPolicy", "Background")
+	if DryRun == DryRunServer {
+		query.Set("dryRun", "All")
+	}
+	for _, obj := range objects {
+		objURL, err := n.objectURL(ctx, obj, query)
+		if err != nil {
+			return err
+		}
+		_, err = n.do(ctx, "DELETE", objURL, "", nil)
+		if err != nil {
+			glog.Errorf("%sNative failed deleting '%s': %v", prefix, filePath, err)
+			return err
+		}
+	}
+	glog.Infof("%sNative successfully deleted '%s'", prefix, filePath)
+	return nil
+}
+
+func (n *Native) Wait(ctx context.Context, filePath string, readiness Readiness) error {
+	if DryRun != "" {
+		glog.Infof("%sDry run: skipping check of '%s'", LogPrefix(ctx), filePath)
+		return nil
+	}
+	return WaitUntilReady(ctx, n, filePath, readiness)
+}
+
+func (n *Native) Exists(ctx context.Context, filePath string) (bool, error) {
+	if DryRun == DryRunNone {
+		glog.Infof("Dry run: assuming '%s' is not in the cluster", filePath)
+		return false, nil
+	}
+	objects, err := ReadObjects(filePath)
+	if err != nil {
+		return false, err
+	}
+	for _, obj := range objects {
+		_, err := n.get(ctx, obj)
+		if isNotFound(err) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// Diffs the live objects against the result of a server-side dry-run apply of
+// the file, ignoring the fields managed by the server.
+func (n *Native) Diff(ctx context.Context, filePath string) (string, bool, error) {
+	if DryRun == DryRunNone {
+		glog.Infof("Dry run: native would diff '%s'", filePath)
+		return "", false, nil
+	}
+	objects, err := ReadObjects(filePath)
+	if err != nil {
+		return "", false, err
+	}
+
+	diff := ""
+	for _, obj := range objects {
+		live, err := n.get(ctx, obj)
+		if isNotFound(err) {
+			live = map[string]interface{}{}
+		} else if err != nil {
+			return "", false, err
+		}
+		desired, err := n.apply(ctx, obj, true)
+		if err != nil {
+			return "", false, err
+		}
+
+		live, desired = withoutServerFields(live), withoutServerFields(desired)
+		if reflect.DeepEqual(live, desired) {
+			continue
+		}
+		key := ObjectKey(obj)
+		diff += fmt.Sprintf("--- live/%s\n+++ desired/%s\n", key, key)
+		diff += diffLines(toLines(live), toLines(desired))
+	}
+	return diff, diff != "", nil
+}
+
+// Streams the changes to the objects of the file using the API server's watch
+// endpoint, one request per object
+func (n *Native) Watch(ctx context.Context, filePath string) (<-chan []byte, error) {
+	objects, err := ReadObjects(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := []*http.Response{}
+	for _, obj := range objects {
+		collectionURL, name, err := n.collectionURL(ctx, obj)
+		if err != nil {
+			closeAll(responses)
+			return nil, err
+		}
+		query := url.Values{}
+		query.Set("watch", "true")
+		query.Set("fieldSelector", "metadata.name="+name)
+		resp, err := n.stream(ctx, collectionURL+"?"+query.Encode())
+		if err != nil {
+			closeAll(responses)
+			return nil, err
+		}
+		responses = append(responses, resp)
+	}
+
+	events := make(chan []byte)
+	var wg sync.WaitGroup
+	for _, resp := range responses {
+		wg.Add(1)
+		go func(resp *http.Response) {
+			defer wg.Done()
+			defer resp.Body.Close()
+			decoder := json.NewDecoder(resp.Body)
+			for {
+				event := struct {
+					Type   string
+					Object json.RawMessage
+				}{}
+				if err := decoder.Decode(&event); err != nil {
+					return
+				}
+				if event.Type == "ERROR" {
+					glog.Warningf("Native watch of '%s' failed: %s", filePath, string(event.Object))
+					return
+				}
+				select {
+				case events <- []byte(event.Object):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(resp)
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+	return events, nil
+}
+
+// Lists the matching objects of every kind that can be listed and deleted,
+// going through the preferred version of every API group
+func (n *Native) List(ctx context.Context, selector string) ([]map[string]interface{}, error) {
+	glog.V(2).Infof("Native listing objects matching '%s'", selector)
+	if DryRun == DryRunNone {
+		glog.Infof("Dry run: assuming no object matches '%s'", selector)
+		return []map[string]interface{}{}, nil
+	}
+
+	groupVersions, err := n.groupVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	query.Set("labelSelector", selector)
+
+	objects := []map[string]interface{}{}
+	for _, groupVersion := range groupVersions {
+		resources, err := n.discover(ctx, groupVersion)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range resources {
+			if strings.Contains(resource.Name, "/") || !hasVerb(resource, "list") || !hasVerb(resource, "delete") {
+				continue
+			}
+			listURL := n.Config.Server + groupVersionPath(groupVersion) + "/" + resource.Name + "?" + query.Encode()
+			list, err := n.do(ctx, "GET", listURL, "", nil)
+			if err != nil {
+				return nil, err
+			}
+			items, _ := list["items"].([]interface{})
+			for _, item := range items {
+				obj, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				// Items of a list do not carry their own type
+				obj["apiVersion"] = groupVersion
+				obj["kind"] = resource.Kind
+				objects = append(objects, obj)
+			}
+		}
+	}
+	return objects, nil
+}
+
+func (n *Native) ListKind(ctx context.Context, apiVersion, kind, namespace, selector string) ([]map[string]interface{}, error) {
+	glog.V(2).Infof("Native listing %s objects matching '%s'", kind, selector)
+	if DryRun == DryRunNone {
+		glog.Infof("Dry run: assuming no %s matches '%s'", kind, selector)
+		return []map[string]interface{}{}, nil
+	}
+
+	resource, err := n.findResource(ctx, apiVersion, kind)
+	if err != nil {
+		return nil, err
+	}
+	listURL := n.Config.Server + groupVersionPath(apiVersion)
+	if resource.Namespaced {
+		if namespace == "" {
+			namespace, _ = n.DefaultNamespace(ctx)
+		}
+		listURL += "/namespaces/" + url.PathEscape(namespace)
+	}
+	query := url.Values{}
+	query.Set("labelSelector", selector)
+	list, err := n.do(ctx, "GET", listURL+"/"+resource.Name+"?"+query.Encode(), "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []map[string]interface{}{}
+	items, _ := list["items"].([]interface{})
+	for _, item := range items {
+		if obj, ok := item.(map[string]interface{}); ok {
+			obj["apiVersion"] = apiVersion
+			obj["kind"] = kind
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+// Returns the namespace of the context, "default" when it has none
+func (n *Native) DefaultNamespace(ctx context.Context) (string, error) {
+	if n.Config.Namespace != "" {
+		return n.Config.Namespace, nil
+	}
+	return "default", nil
+}
+
+// Returns the core version along with the preferred version of every group
+func (n *Native) groupVersions(ctx context.Context) ([]string, error) {
+	groupVersions := []string{"v1"}
+	groups, err := n.do(ctx, "GET", n.Config.Server+"/apis", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	list, _ := groups["groups"].([]interface{})
+	for _, group := range list {
+		groupMap, _ := group.(map[string]interface{})
+		preferred, _ := groupMap["preferredVersion"].(map[string]interface{})
+		if groupVersion, ok := preferred["groupVersion"].(string); ok {
+			groupVersions = append(groupVersions, groupVersion)
+		}
+	}
+	return groupVersions, nil
+}
+
+func (n *Native) apply(ctx context.Context, obj map[string]interface{}, dryRun bool) (map[string]interface{}, error) {
+	query := url.Values{}
+	query.Set("fieldManager", FieldManager)
+	query.Set("force", "true")
+	if dryRun {
+		query.Set("dryRun", "All")
+	}
+	objURL, err := n.objectURL(ctx, obj, query)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	return n.do(ctx, "PATCH", objURL, "application/apply-patch+yaml", body)
+}
+
+func (n *Native) get(ctx context.Context, obj map[string]interface{}) (map[string]interface{}, error) {
+	objURL, err := n.objectURL(ctx, obj, nil)
+	if err != nil {
+		return nil, err
+	}
+	return n.do(ctx, "GET", objURL, "", nil)
+}
+
+// Builds the URL of the object, looking up its resource name and scope with
+// the API server's discovery endpoint
+func (n *Native) objectURL(ctx context.Context, obj map[string]interface{}, query url.Values) (string, error) {
+	collectionURL, name, err := n.collectionURL(ctx, obj)
+	if err != nil {
+		return "", err
+	}
+	objURL := collectionURL + "/" + url.PathEscape(name)
+	if len(query) > 0 {
+		objURL += "?" + query.Encode()
+	}
+	return objURL, nil
+}
+
+// Returns the URL of the collection the object belongs to, along with the
+// object's name
+func (n *Native) collectionURL(ctx context.Context, obj map[string]interface{}) (string, string, error) {
+	apiVersion, _ := obj["apiVersion"].(string)
+	kind, _ := obj["kind"].(string)
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	if apiVersion == "" || kind == "" || name == "" {
+		return "", "", fmt.Errorf("Object is missing its apiVersion, kind or metadata.name")
+	}
+
+	resource, err := n.findResource(ctx, apiVersion, kind)
+	if err != nil {
+		return "", "", err
+	}
+
+	collectionURL := n.Config.Server + groupVersionPath(apiVersion)
+	if resource.Namespaced {
+		namespace, _ := metadata["namespace"].(string)
+		if namespace == "" {
+			namespace, _ = n.DefaultNamespace(ctx)
+		}
+		collectionURL += "/namespaces/" + url.PathEscape(namespace)
+	}
+	return collectionURL + "/" + resource.Name, name, nil
+}
+
+func (n *Native) findResource(ctx context.Context, apiVersion, kind string) (apiResource, error) {
+	resources, err := n.discover(ctx, apiVersion)
+	if err != nil {
+		return apiResource{}, err
+	}
+	for _, resource := range resources {
+		if resource.Kind == kind && !strings.Contains(resource.Name, "/") {
+			return resource, nil
+		}
+	}
+	return apiResource{}, fmt.Errorf("Kind '%s' not served by the API server for '%s'", kind, apiVersion)
+}
+
+// Returns the resources served for the API version, caching them for the
+// lifetime of the client
+func (n *Native) discover(ctx context.Context, apiVersion string) ([]apiResource, error) {
+	n.mutex.Lock()
+	resources, found := n.discovery[apiVersion]
+	n.mutex.Unlock()
+	if found {
+		return resources, nil
+	}
+
+	list, err := n.do(ctx, "GET", n.Config.Server+groupVersionPath(apiVersion), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	content, _ := json.Marshal(list["resources"])
+	err = json.Unmarshal(content, &resources)
+	if err != nil {
+		return nil, err
+	}
+	n.mutex.Lock()
+	n.discovery[apiVersion] = resources
+	n.mutex.Unlock()
+	return resources, nil
+}
+
+func (n *Native) stream(ctx context.Context, reqURL string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	n.authorize(req)
+
+	glog.V(3).Infof("Native WATCH %s", reqURL)
+	resp, err := n.Config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		content, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &statusError{Code: resp.StatusCode, Message: strings.TrimSpace(string(content))}
+	}
+	return resp, nil
+}
+
+func (n *Native) authorize(req *http.Request) {
+	if n.Config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Config.Token)
+	} else if n.Config.Username != "" {
+		req.SetBasicAuth(n.Config.Username, n.Config.Password)
+	}
+}
+
+func (n *Native) do(ctx context.Context, method, reqURL, contentType string, body []byte) (map[string]interface{}, error) {
+	req, err := http.NewRequest(method, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	n.authorize(req)
+
+	glog.V(3).Infof("Native %s %s", method, reqURL)
+	resp, err := n.Config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]interface{})
+	if len(content) > 0 {
+		err = json.Unmarshal(content, &ret)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := ret["message"].(string)
+		if message == "" {
+			message = strings.TrimSpace(string(content))
+		}
+		return nil, &statusError{Code: resp.StatusCode, Message: message}
+	}
+	return ret, err
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+func groupVersionPath(apiVersion string) string {
+	if !strings.Contains(apiVersion, "/") {
+		return "/api/" + apiVersion
+	}
+	return "/apis/" + apiVersion
+}
+
+func hasVerb(resource apiResource, verb string) bool {
+	for _, v := range resource.Verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+func closeAll(responses []*http.Response) {
+	for _, resp := range responses {
+		resp.Body.Close()
+	}
+}
+
+func isNotFound(err error) bool {
+	statusErr, ok := err.(*statusError)
+	return ok && statusErr.Code == http.StatusNotFound
+}
+
+// Strips the status and the metadata that the server sets on its own
+func withoutServerFields(obj map[string]interface{}) map[string]interface{} {
+	ret := withoutStatus(obj)
+	metadata, ok := ret["metadata"].(map[string]interface{})
+	if !ok {
+		return ret
+	}
+	stripped := make(map[string]interface{})
+	for k, v := range metadata {
+		switch k {
+		case "managedFields", "resourceVersion", "uid", "generation", "creationTimestamp", "selfLink":
+			continue
+		}
+		stripped[k] = v
+	}
+	ret["metadata"] = stripped
+	return ret
+}
@@ -0,0 +1,388 @@
+package kubectl
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// apiServer is an in-memory API server serving ConfigMaps, Namespaces and
+// Deployments, recording the requests it is sent
+type apiServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	objects  map[string]map[string]interface{}
+	requests []*http.Request
+	bodies   []string
+	events   chan string
+}
+
+var apiResources = map[string]string{
+	"/api/v1": `{"resources": [
+		{"name": "configmaps", "kind": "ConfigMap", "namespaced": true, "verbs": ["get", "list", "delete", "patch", "watch"]},
+		{"name": "namespaces", "kind": "Namespace", "namespaced": false, "verbs": ["get", "list", "delete", "patch"]},
+		{"name": "namespaces/status", "kind": "Namespace", "namespaced": false, "verbs": ["get"]},
+		{"name": "events", "kind": "Event", "namespaced": true, "verbs": ["get", "list"]}
+	]}`,
+	"/apis":         `{"groups": [{"name": "apps", "preferredVersion": {"groupVersion": "apps/v1"}}]}`,
+	"/apis/apps/v1": `{"resources": [{"name": "deployments", "kind": "Deployment", "namespaced": true, "verbs": ["get", "list", "delete", "patch"]}]}`,
+}
+
+func newAPIServer(t *testing.T) *apiServer {
+	s := &apiServer{objects: make(map[string]map[string]interface{}), events: make(chan string, 10)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *apiServer) serve(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	s.mutex.Lock()
+	s.requests = append(s.requests, req)
+	s.bodies = append(s.bodies, string(body))
+	s.mutex.Unlock()
+
+	if discovery, found := apiResources[req.URL.Path]; found {
+		w.Write([]byte(discovery))
+		return
+	}
+	if req.URL.Query().Get("watch") == "true" {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event := <-s.events:
+				w.Write([]byte(event + "\n"))
+				w.(http.Flusher).Flush()
+			case <-req.Context().Done():
+				return
+			}
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if selector := req.URL.Query().Get("labelSelector"); selector != "" {
+		// Lists are cluster-wide: /api/v1/configmaps lists the ConfigMaps of
+		// every namespace
+		label := strings.SplitN(selector, "=", 2)
+		group, resource := path.Dir(req.URL.Path), path.Base(req.URL.Path)
+		items := []interface{}{}
+		for objPath, obj := range s.objects {
+			metadata, _ := obj["metadata"].(map[string]interface{})
+			labels, _ := metadata["labels"].(map[string]interface{})
+			inCollection := strings.HasPrefix(objPath, group+"/") && path.Base(path.Dir(objPath)) == resource
+			if inCollection && labels[label[0]] == label[1] {
+				items = append(items, obj)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+		return
+	}
+
+	obj, found := s.objects[req.URL.Path]
+	switch req.Method {
+	case "PATCH":
+		obj = make(map[string]interface{})
+		json.Unmarshal(body, &obj)
+		if req.URL.Query().Get("dryRun") == "" {
+			s.objects[req.URL.Path] = obj
+		}
+	case "DELETE":
+		delete(s.objects, req.URL.Path)
+	}
+	if !found && req.Method != "PATCH" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"kind": "Status", "message": "not found"}`))
+		return
+	}
+	json.NewEncoder(w).Encode(obj)
+}
+
+func (s *apiServer) lastRequest(method string) (*http.Request, string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := len(s.requests) - 1; i >= 0; i-- {
+		if s.requests[i].Method == method {
+			return s.requests[i], s.bodies[i]
+		}
+	}
+	return nil, ""
+}
+
+func (s *apiServer) count(urlPath string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := 0
+	for _, req := range s.requests {
+		if req.URL.Path == urlPath {
+			n++
+		}
+	}
+	return n
+}
+
+func newTestNative(s *apiServer) *Native {
+	return NewNative(RESTConfig{Server: s.URL, Namespace: "team", Token: "secret"})
+}
+
+func writeManifest(t *testing.T, manifest string) string {
+	filePath := path.Join(t.TempDir(), "manifest.json")
+	err := ioutil.WriteFile(filePath, []byte(manifest), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+const configMapManifest = `{
+	"apiVersion": "v1", "kind": "ConfigMap",
+	"metadata": {"name": "app-config", "labels": {"kubemgr.io/package": "app"}},
+	"data": {"level": "debug"}
+}`
+
+func TestNativeDiscoversResourceURLs(t *testing.T) {
+	server := newAPIServer(t)
+	native := newTestNative(server)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		manifest string
+		want     string
+	}{
+		{configMapManifest, "/api/v1/namespaces/team/configmaps/app-config"},
+		{`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "other", "namespace": "ops"}}`, "/api/v1/namespaces/ops/configmaps/other"},
+		{`{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "team"}}`, "/api/v1/namespaces/team"},
+		{`{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "app"}}`, "/apis/apps/v1/namespaces/team/deployments/app"},
+	} {
+		objects, err := DecodeObjects([]byte(tc.manifest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := native.objectURL(ctx, objects[0], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != server.URL+tc.want {
+			t.Errorf("Got %s, want %s", got, tc.want)
+		}
+	}
+
+	// Discovery is only done once per API version
+	if n := server.count("/api/v1"); n != 1 {
+		t.Errorf("Discovered /api/v1 %d times, want 1", n)
+	}
+
+	objects, _ := DecodeObjects([]byte(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "app"}}`))
+	_, err := native.objectURL(ctx, objects[0], nil)
+	if err == nil || !strings.Contains(err.Error(), "not served") {
+		t.Errorf("Got %v for an unknown kind, want an error", err)
+	}
+}
+
+func TestNativeAppliesServerSide(t *testing.T) {
+	server := newAPIServer(t)
+	native := newTestNative(server)
+	filePath := writeManifest(t, configMapManifest)
+
+	err := native.Apply(context.Background(), filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, body := server.lastRequest("PATCH")
+	if req == nil {
+		t.Fatal("No PATCH request was sent")
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "application/apply-patch+yaml" {
+		t.Errorf("Got content type %s, want application/apply-patch+yaml", ct)
+	}
+	if auth := req.Header.Get("Authorization"); auth != "Bearer secret" {
+		t.Errorf("Got authorization %q", auth)
+	}
+	query := req.URL.Query()
+	if query.Get("fieldManager") != FieldManager || query.Get("force") != "true" {
+		t.Errorf("Got query %v, want the kubemgr field manager forcing conflicts", query)
+	}
+	if !strings.Contains(body, `"level":"debug"`) {
+		t.Errorf("Got body %s", body)
+	}
+}
+
+func TestNativeGetsAndDeletes(t *testing.T) {
+	server := newAPIServer(t)
+	native := newTestNative(server)
+	ctx := context.Background()
+	filePath := writeManifest(t, configMapManifest)
+
+	exists, err := native.Exists(ctx, filePath)
+	if err != nil || exists {
+		t.Fatalf("Got %v, %v before applying, want false", exists, err)
+	}
+	err = native.Apply(ctx, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := native.Get(ctx, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `"app-config"`) {
+		t.Errorf("Got %s", out)
+	}
+
+	err = native.Delete(ctx, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := server.lastRequest("DELETE")
+	if req.URL.Path != "/api/v1/namespaces/team/configmaps/app-config" || req.URL.Query().Get("propagationPolicy") != "Background" {
+		t.Errorf("Got DELETE %s", req.URL)
+	}
+	exists, err = native.Exists(ctx, filePath)
+	if err != nil || exists {
+		t.Errorf("Got %v, %v after deleting, want false", exists, err)
+	}
+	err = native.Delete(ctx, filePath)
+	if !isNotFound(err) {
+		t.Errorf("Got %v deleting a missing object, want a not found error", err)
+	}
+}
+
+func TestNativeWatchesObjects(t *testing.T) {
+	server := newAPIServer(t)
+	native := newTestNative(server)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	filePath := writeManifest(t, configMapManifest)
+
+	events, err := native.Watch(ctx, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := server.lastRequest("GET")
+	if req.URL.Path != "/api/v1/namespaces/team/configmaps" || req.URL.Query().Get("fieldSelector") != "metadata.name=app-config" {
+		t.Errorf("Got watch request %s", req.URL)
+	}
+
+	server.events <- `{"type": "MODIFIED", "object": {"metadata": {"name": "app-config", "resourceVersion": "2"}}}`
+	select {
+	case event := <-events:
+		if !strings.Contains(string(event), `"resourceVersion": "2"`) {
+			t.Errorf("Got event %s", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event received")
+	}
+
+	// An error event ends the watch
+	server.events <- `{"type": "ERROR", "object": {"message": "too old resource version"}}`
+	select {
+	case _, open := <-events:
+		if open {
+			t.Error("Watch went on after an error event")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not end after an error event")
+	}
+}
+
+func TestNativeListsEveryKind(t *testing.T) {
+	server := newAPIServer(t)
+	native := newTestNative(server)
+	ctx := context.Background()
+	for _, manifest := range []string{
+		configMapManifest,
+		`{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "app", "labels": {"kubemgr.io/package": "app"}}}`,
+		`{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "other", "labels": {"kubemgr.io/package": "other"}}}`,
+	} {
+		err := native.Apply(ctx, writeManifest(t, manifest))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objects, err := native.List(ctx, "kubemgr.io/package=app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, obj := range objects {
+		keys = append(keys, obj["apiVersion"].(string)+" "+ObjectKey(obj))
+	}
+	if len(keys) != 2 || !strings.Contains(strings.Join(keys, ","), "apps/v1") {
+		t.Errorf("Got %v, want the ConfigMap and the Deployment of the package", keys)
+	}
+
+	// Kinds that cannot be deleted or that are subresources are never listed
+	for _, skipped := range []string{"/api/v1/events", "/api/v1/namespaces/status"} {
+		if n := server.count(skipped); n != 0 {
+			t.Errorf("Listed %s", skipped)
+		}
+	}
+}
+
+func TestNativeListsOneKindInOneNamespace(t *testing.T) {
+	server := newAPIServer(t)
+	native := newTestNative(server)
+	ctx := context.Background()
+	for _, manifest := range []string{
+		configMapManifest,
+		`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "app-config", "namespace": "ops", "labels": {"kubemgr.io/package": "app"}}}`,
+		`{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "app", "labels": {"kubemgr.io/package": "app"}}}`,
+	} {
+		err := native.Apply(ctx, writeManifest(t, manifest))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objects, err := native.ListKind(ctx, "v1", "ConfigMap", "", "kubemgr.io/package=app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0]["kind"] != "ConfigMap" {
+		t.Errorf("Got %v, want the ConfigMap of the team namespace", objects)
+	}
+	req, _ := server.lastRequest("GET")
+	if req.URL.Path != "/api/v1/namespaces/team/configmaps" {
+		t.Errorf("Got list request %s", req.URL)
+	}
+}
+
+func TestNativeDryRun(t *testing.T) {
+	defer func(previous string) { DryRun = previous }(DryRun)
+	server := newAPIServer(t)
+	native := newTestNative(server)
+	filePath := writeManifest(t, configMapManifest)
+
+	// A client dry run never reaches the API server
+	DryRun = DryRunClient
+	err := native.Apply(context.Background(), filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req, _ := server.lastRequest("PATCH"); req != nil {
+		t.Errorf("Client dry run sent %s", req.URL)
+	}
+
+	// A server dry run is validated by the API server without being persisted
+	DryRun = DryRunServer
+	err = native.Apply(context.Background(), filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := server.lastRequest("PATCH")
+	if req == nil || req.URL.Query().Get("dryRun") != "All" {
+		t.Fatalf("Got %v, want a PATCH with dryRun=All", req)
+	}
+	if len(server.objects) != 0 {
+		t.Errorf("Server dry run persisted %v", server.objects)
+	}
+}
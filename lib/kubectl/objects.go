@@ -0,0 +1,110 @@
+package kubectl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/ghodss/yaml"
+)
+
+var (
+	documentSeparator = regexp.MustCompile(`(?m)^---[ \t]*(#.*)?$`)
+)
+
+// Reads the objects of a manifest. The file can hold several objects one
+// after the other, and List kinds are flattened into their items.
+func ReadObjects(filePath string) ([]map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeObjects(content)
+}
+
+// Decodes a stream of JSON objects, or of YAML documents separated by '---'
+func DecodeObjects(content []byte) ([]map[string]interface{}, error) {
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) > 0 && trimmed[0] != '{' {
+		return decodeYAMLObjects(content)
+	}
+
+	objects := []map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	for {
+		obj := make(map[string]interface{})
+		err := decoder.Decode(&obj)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		objects = append(objects, flattenList(obj)...)
+	}
+	return objects, nil
+}
+
+func decodeYAMLObjects(content []byte) ([]map[string]interface{}, error) {
+	objects := []map[string]interface{}{}
+	for i, doc := range documentSeparator.Split(string(content), -1) {
+		obj := make(map[string]interface{})
+		err := yaml.Unmarshal([]byte(doc), &obj)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid YAML document %d: %v", i+1, err)
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		objects = append(objects, flattenList(obj)...)
+	}
+	return objects, nil
+}
+
+// Writes the objects to a manifest, wrapping them in a List when there are
+// several of them
+func WriteObjects(filePath string, objects []map[string]interface{}) error {
+	var content []byte
+	var err error
+	if len(objects) == 1 {
+		content, err = json.MarshalIndent(objects[0], "", "  ")
+	} else {
+		content, err = json.MarshalIndent(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "List",
+			"items":      objects,
+		}, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, content, 0644)
+}
+
+func flattenList(obj map[string]interface{}) []map[string]interface{} {
+	if obj["kind"] != "List" {
+		return []map[string]interface{}{obj}
+	}
+
+	items, _ := obj["items"].([]interface{})
+	objects := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		if itemObj, ok := item.(map[string]interface{}); ok {
+			objects = append(objects, flattenList(itemObj)...)
+		}
+	}
+	return objects
+}
+
+// Returns the key identifying the object in the cluster: Kind/namespace/name
+func ObjectKey(obj map[string]interface{}) string {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	namespace, _ := metadata["namespace"].(string)
+	name, _ := metadata["name"].(string)
+	if namespace == "" {
+		namespace = "default"
+	}
+	return fmt.Sprintf("%v/%s/%s", obj["kind"], namespace, name)
+}
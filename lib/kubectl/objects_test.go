@@ -0,0 +1,81 @@
+package kubectl
+
+import (
+	"strings"
+	"testing"
+)
+
+// Returns the names of the objects, in order
+func objectNames(objects []map[string]interface{}) []string {
+	names := []string{}
+	for _, obj := range objects {
+		metadata, _ := obj["metadata"].(map[string]interface{})
+		name, _ := metadata["name"].(string)
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestDecodeObjects(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"json stream", `{"metadata": {"name": "a"}} {"metadata": {"name": "b"}}`, "a,b"},
+		{"json list", `{"kind": "List", "items": [{"metadata": {"name": "a"}}, {"metadata": {"name": "b"}}]}`, "a,b"},
+		{"json with separator", `{"metadata": {"name": "a", "annotations": {"sep": "\n---\n"}}}`, "a"},
+		{"single document", "metadata:\n  name: a\n", "a"},
+		{"documents", "metadata:\n  name: a\n---\nmetadata:\n  name: b\n", "a,b"},
+		{"leading separator", "---\nmetadata:\n  name: a\n", "a"},
+		{"empty documents", "---\n---\nmetadata:\n  name: a\n---\n\n---\n# nothing here\n---\nmetadata:\n  name: b\n---\n", "a,b"},
+		{"commented separator", "metadata:\n  name: a\n--- # next\nmetadata:\n  name: b\n", "a,b"},
+		{"separator in strings", `metadata:
+  name: a
+  annotations:
+    quoted: "---"
+    plain: --- not a separator
+data:
+  script: |
+    echo start
+    ---
+    echo end
+---
+metadata:
+  name: b
+`, "a,b"},
+		{"yaml list", "kind: List\nitems:\n  - metadata:\n      name: a\n  - kind: List\n    items:\n      - metadata:\n          name: b\n", "a,b"},
+		{"empty", "", ""},
+	} {
+		objects, err := DecodeObjects([]byte(tc.content))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := strings.Join(objectNames(objects), ","); got != tc.want {
+			t.Errorf("%s: got objects %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDecodeObjectsKeepsStrings(t *testing.T) {
+	objects, err := DecodeObjects([]byte("metadata:\n  name: a\ndata:\n  script: |\n    echo start\n    ---\n    echo end\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := objects[0]["data"].(map[string]interface{})
+	if data["script"] != "echo start\n---\necho end\n" {
+		t.Errorf("Got script %q", data["script"])
+	}
+}
+
+func TestDecodeObjectsErrors(t *testing.T) {
+	_, err := DecodeObjects([]byte("metadata:\n  name: a\n---\nmetadata: [b\n"))
+	if err == nil || !strings.Contains(err.Error(), "Invalid YAML document 2") {
+		t.Errorf("Got %v, want the second document to be invalid", err)
+	}
+	_, err = DecodeObjects([]byte(`{"metadata": {"name": "a"}} {"metadata": `))
+	if err == nil {
+		t.Error("Truncated JSON stream was decoded")
+	}
+}
@@ -0,0 +1,495 @@
+package kubectl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+type Resource struct {
+	Kind     string
+	Metadata map[string]interface{}
+	Spec     map[string]interface{}
+	Status   map[string]interface{}
+	Items    []Resource
+}
+
+const (
+	CheckSleep = 2000 * time.Millisecond
+)
+
+var (
+	CheckRetries = 20
+)
+
+func init() {
+	flag.IntVar(&CheckRetries, "retries", 20, "Number of times to retry the check")
+}
+
+// Readiness is the policy used to wait for the objects of a resource. Durations
+// are written like "30s" or "5m"; the interval is multiplied by Backoff after
+// every attempt when it is greater than 1. A custom Condition replaces the
+// built-in readiness check of each object's kind.
+type Readiness struct {
+	Timeout   string
+	Interval  string
+	Backoff   float64
+	Skip      bool
+	Condition string
+}
+
+type waitPolicy struct {
+	timeout   time.Duration
+	interval  time.Duration
+	backoff   float64
+	condition *Condition
+}
+
+func (p Readiness) Validate() error {
+	_, err := p.compile()
+	return err
+}
+
+// Resolves the policy, defaulting to polling every CheckSleep for CheckRetries
+// attempts
+func (p Readiness) compile() (waitPolicy, error) {
+	policy := waitPolicy{interval: CheckSleep, backoff: 1}
+	var err error
+	if p.Interval != "" {
+		policy.interval, err = time.ParseDuration(p.Interval)
+		if err != nil {
+			return policy, fmt.Errorf("Invalid readiness interval: %v", err)
+		}
+		if policy.interval <= 0 {
+			return policy, fmt.Errorf("Invalid readiness interval: must be positive")
+		}
+	}
+	policy.timeout = time.Duration(CheckRetries) * policy.interval
+	if p.Timeout != "" {
+		policy.timeout, err = time.ParseDuration(p.Timeout)
+		if err != nil {
+			return policy, fmt.Errorf("Invalid readiness timeout: %v", err)
+		}
+		if policy.timeout <= 0 {
+			return policy, fmt.Errorf("Invalid readiness timeout: must be positive")
+		}
+	}
+	// A backoff of 0 is one that is not set
+	if p.Backoff != 0 && p.Backoff < 1 {
+		return policy, fmt.Errorf("Invalid readiness backoff: must be at least 1")
+	} else if p.Backoff != 0 {
+		policy.backoff = p.Backoff
+	}
+	if p.Condition != "" {
+		policy.condition, err = ParseCondition(p.Condition)
+		if err != nil {
+			return policy, err
+		}
+	}
+	return policy, nil
+}
+
+// Waits until the objects of the file are ready according to the policy,
+// giving up early if the context is cancelled. Clients that are Watchers are
+// watched so that the wait ends as soon as the objects are ready, the others
+// are polled.
+func WaitUntilReady(ctx context.Context, client Client, filePath string, readiness Readiness) error {
+	prefix := LogPrefix(ctx)
+	if readiness.Skip {
+		glog.Infof("%sSkipping check of '%s'", prefix, filePath)
+		return nil
+	}
+	policy, err := readiness.compile()
+	if err != nil {
+		return err
+	}
+	glog.Infof("%sChecking '%s'", prefix, filePath)
+
+	waitCtx, cancel := context.WithTimeout(ctx, policy.timeout)
+	defer cancel()
+	if watcher, ok := client.(Watcher); ok {
+		err = policy.watch(waitCtx, watcher, filePath, prefix)
+		if err != nil && waitCtx.Err() == nil {
+			if _, ok := err.(permanentError); !ok {
+				glog.Warningf("%sWatching '%s' failed: %v => polling", prefix, filePath, err)
+				err = policy.poll(waitCtx, client, filePath, prefix)
+			}
+		}
+	} else {
+		err = policy.poll(waitCtx, client, filePath, prefix)
+	}
+
+	if err != nil && ctx.Err() != nil {
+		glog.Errorf("%sStopped checking '%s': %v", prefix, filePath, ctx.Err())
+		return ctx.Err()
+	} else if err != nil && waitCtx.Err() != nil {
+		glog.Errorf("%sFailed checking '%s'", prefix, filePath)
+		return fmt.Errorf("Timed out after %v waiting for '%s' to be ready: %v", policy.timeout, filePath, err)
+	} else if err != nil {
+		glog.Errorf("%sFailed checking '%s': %v", prefix, filePath, err)
+		return err
+	}
+
+	glog.Infof("%sSuccessfully checked '%s'", prefix, filePath)
+	return nil
+}
+
+// Gets the objects until they are ready, returning the last failure once the
+// context is done
+func (p waitPolicy) poll(ctx context.Context, client Client, filePath, prefix string) error {
+	var err error
+	interval := p.interval
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(interval):
+			}
+			interval = time.Duration(float64(interval) * p.backoff)
+		}
+
+		var out []byte
+		out, err = client.Get(ctx, filePath)
+		if err == nil {
+			err = p.check(out, prefix)
+		}
+		if _, ok := err.(permanentError); ok || err == nil {
+			return err
+		}
+	}
+}
+
+// Follows the changes to the objects until they are ready, returning the last
+// failure once the context is done
+func (p waitPolicy) watch(ctx context.Context, watcher Watcher, filePath, prefix string) error {
+	desired, err := ReadObjects(filePath)
+	if err != nil {
+		return err
+	}
+	events, err := watcher.Watch(ctx, filePath)
+	if err != nil {
+		return err
+	}
+
+	err = fmt.Errorf("No objects seen yet")
+	latest := make(map[string]interface{})
+	keys := []string{}
+	for {
+		select {
+		case <-ctx.Done():
+			return err
+		case event, ok := <-events:
+			if !ok {
+				return fmt.Errorf("Watch ended before '%s' was ready", filePath)
+			}
+			objects, decodeErr := DecodeObjects(event)
+			if decodeErr != nil {
+				return decodeErr
+			}
+			for _, obj := range objects {
+				key := ObjectKey(obj)
+				if _, found := latest[key]; !found {
+					keys = append(keys, key)
+				}
+				latest[key] = obj
+			}
+			if len(keys) < len(desired) {
+				continue
+			}
+
+			items := make([]interface{}, len(keys))
+			for i, key := range keys {
+				items[i] = latest[key]
+			}
+			list, _ := json.Marshal(map[string]interface{}{"kind": "List", "items": items})
+			err = p.check(list, prefix)
+			if _, ok := err.(permanentError); ok || err == nil {
+				return err
+			}
+		}
+	}
+}
+
+func (p waitPolicy) check(out []byte, prefix string) error {
+	if p.condition == nil {
+		resources, err := decodeResources(out)
+		if err != nil {
+			return err
+		}
+		return checkAll(resources, prefix)
+	}
+
+	objects, err := DecodeObjects(out)
+	if err != nil {
+		return err
+	}
+	pending := []string{}
+	for _, obj := range objects {
+		if met, values := p.condition.Eval(obj); !met {
+			glog.Infof("%s%s: '%s' got %v", prefix, ObjectKey(obj), p.condition.Expr, values)
+			pending = append(pending, fmt.Sprintf("%s: condition '%s' not met", ObjectKey(obj), p.condition.Expr))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d/%d objects not ready: %s", len(pending), len(objects), strings.Join(pending, "; "))
+	}
+	return nil
+}
+
+// Failure that waiting longer cannot fix, like a Job that has failed
+type permanentError struct {
+	error
+}
+
+// Checks every object and reports the ones that are still pending
+func checkAll(resources []Resource, prefix string) error {
+	pending := []string{}
+	for _, res := range resources {
+		err := res.check(prefix + res.Name() + ": ")
+		if permanent, ok := err.(permanentError); ok {
+			return permanentError{fmt.Errorf("%s: %v", res.Name(), permanent.error)}
+		}
+		if err != nil {
+			pending = append(pending, fmt.Sprintf("%s: %v", res.Name(), err))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d/%d objects not ready: %s", len(pending), len(resources), strings.Join(pending, "; "))
+	}
+	return nil
+}
+
+// Returns a name identifying the object, like "Deployment/incipit/app"
+func (r Resource) Name() string {
+	namespace, _ := r.Metadata["namespace"].(string)
+	name, _ := r.Metadata["name"].(string)
+	if namespace == "" {
+		return r.Kind + "/" + name
+	}
+	return r.Kind + "/" + namespace + "/" + name
+}
+
+func (r Resource) check(prefix string) error {
+	switch r.Kind {
+	case "Service":
+		return r.checkService(prefix)
+	case "Deployment":
+		return r.checkDeployment(prefix)
+	case "StatefulSet":
+		return r.checkStatefulSet(prefix)
+	case "DaemonSet":
+		return r.checkDaemonSet(prefix)
+	case "Job":
+		return r.checkJob(prefix)
+	case "PersistentVolumeClaim":
+		return r.checkPersistentVolumeClaim(prefix)
+	case "Pod":
+		return r.checkPod(prefix)
+	case "Ingress":
+		return r.checkLoadBalancer(prefix)
+	}
+	return nil
+}
+
+// A claim whose volume is lost never gets bound again
+func (r Resource) checkPersistentVolumeClaim(prefix string) error {
+	phase, _ := r.Status["phase"].(string)
+	switch phase {
+	case "Bound":
+		return nil
+	case "Lost":
+		return permanentError{fmt.Errorf("PersistentVolumeClaim lost its volume.")}
+	}
+	glog.Infof("%sphase %s", prefix, phase)
+	return fmt.Errorf("PersistentVolumeClaim not bound: phase is '%s'.", phase)
+}
+
+func (r Resource) checkService(prefix string) error {
+	if serviceType, _ := r.Spec["type"].(string); serviceType != "LoadBalancer" {
+		return nil
+	}
+	return r.checkLoadBalancer(prefix)
+}
+
+func (r Resource) checkLoadBalancer(prefix string) error {
+	loadBalancer, _ := r.Status["loadBalancer"].(map[string]interface{})
+	ingress, _ := loadBalancer["ingress"].([]interface{})
+	if len(ingress) > 0 {
+		return nil
+	}
+	glog.Infof("%swaiting for a load balancer ingress", prefix)
+	return fmt.Errorf("%s not ready: no load balancer ingress assigned.", r.Kind)
+}
+
+// A Deployment is only ready once the controller has seen its latest spec and
+// every replica runs the new version, otherwise the replicas of the previous
+// ReplicaSet would be counted as available. Its conditions also describe the
+// previous spec until then, so a rollout that exceeded its deadline is only a
+// failure of the latest spec once it has been observed.
+func (r Resource) checkDeployment(prefix string) error {
+	err := r.checkObserved(prefix)
+	if err != nil {
+		return err
+	}
+	if condition, found := r.condition("Progressing"); found && condition["reason"] == "ProgressDeadlineExceeded" {
+		return permanentError{fmt.Errorf("Deployment rollout failed: %v", condition["message"])}
+	}
+
+	want := intField(r.Spec, "replicas", 1)
+	updated := intField(r.Status, "updatedReplicas", 0)
+	have := intField(r.Status, "availableReplicas", 0)
+	if updated != want {
+		glog.Infof("%s%d/%d updated", prefix, updated, want)
+		return fmt.Errorf("Deployment not ready: want %d updated replicas, has %d.", want, updated)
+	}
+	if want != have {
+		glog.Infof("%s%d/%d available", prefix, have, want)
+		return fmt.Errorf("Deployment not ready: want %d replicas, has %d.", want, have)
+	}
+	return nil
+}
+
+// Like a Deployment, a StatefulSet reports the revisions of its previous spec
+// until the controller has seen the latest one
+func (r Resource) checkStatefulSet(prefix string) error {
+	err := r.checkObserved(prefix)
+	if err != nil {
+		return err
+	}
+
+	want := intField(r.Spec, "replicas", 1)
+	have := intField(r.Status, "readyReplicas", 0)
+	if want != have {
+		glog.Infof("%s%d/%d ready", prefix, have, want)
+		return fmt.Errorf("StatefulSet not ready: want %d replicas, has %d.", want, have)
+	}
+	current, _ := r.Status["currentRevision"].(string)
+	update, _ := r.Status["updateRevision"].(string)
+	if current != update {
+		glog.Infof("%srolling out revision %s, current is %s", prefix, update, current)
+		return fmt.Errorf("StatefulSet not ready: revision %s not rolled out yet.", update)
+	}
+	return nil
+}
+
+// A DaemonSet is only ready once the controller has seen its latest spec and
+// every scheduled pod runs it. A DaemonSet that was just created reports zero
+// pods scheduled and ready, hence the observed generation must be checked.
+func (r Resource) checkDaemonSet(prefix string) error {
+	err := r.checkObserved(prefix)
+	if err != nil {
+		return err
+	}
+
+	want := intField(r.Status, "desiredNumberScheduled", 0)
+	updated := intField(r.Status, "updatedNumberScheduled", 0)
+	have := intField(r.Status, "numberReady", 0)
+	if updated != want {
+		glog.Infof("%s%d/%d updated", prefix, updated, want)
+		return fmt.Errorf("DaemonSet not ready: want %d updated pods, has %d.", want, updated)
+	}
+	if want != have {
+		glog.Infof("%s%d/%d ready", prefix, have, want)
+		return fmt.Errorf("DaemonSet not ready: want %d pods, has %d.", want, have)
+	}
+	return nil
+}
+
+// Fails until the controller has observed the latest generation of the object,
+// before which its status describes the previous spec
+func (r Resource) checkObserved(prefix string) error {
+	generation := intField(r.Metadata, "generation", 0)
+	observed := intField(r.Status, "observedGeneration", 0)
+	if observed < generation {
+		glog.Infof("%sgeneration %d/%d observed", prefix, observed, generation)
+		return fmt.Errorf("%s not ready: generation %d not observed yet.", r.Kind, generation)
+	}
+	return nil
+}
+
+func (r Resource) checkJob(prefix string) error {
+	if condition, found := r.condition("Failed"); found && condition["status"] == "True" {
+		return permanentError{fmt.Errorf("Job failed: %v", condition["message"])}
+	}
+	if condition, found := r.condition("Complete"); found && condition["status"] == "True" {
+		return nil
+	}
+	want := intField(r.Spec, "completions", 1)
+	have := intField(r.Status, "succeeded", 0)
+	if have >= want {
+		return nil
+	}
+	glog.Infof("%s%d/%d completions", prefix, have, want)
+	return fmt.Errorf("Job not complete: want %d completions, has %d.", want, have)
+}
+
+func (r Resource) checkPod(prefix string) error {
+	phase, _ := r.Status["phase"].(string)
+	if phase == "Failed" {
+		return permanentError{fmt.Errorf("Pod failed: %v", r.Status["message"])}
+	}
+	if condition, found := r.condition("Ready"); found && condition["status"] == "True" {
+		return nil
+	}
+	glog.Infof("%sphase %s", prefix, phase)
+	return fmt.Errorf("Pod not ready: phase is '%s'.", phase)
+}
+
+// Returns the status condition of the given type
+func (r Resource) condition(conditionType string) (map[string]interface{}, bool) {
+	conditions, _ := r.Status["conditions"].([]interface{})
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && condition["type"] == conditionType {
+			return condition, true
+		}
+	}
+	return nil, false
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+// Decodes the output of a get, which holds one or more objects, flattening
+// List kinds into their items
+func decodeResources(content []byte) ([]Resource, error) {
+	resources := []Resource{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	for {
+		res := Resource{}
+		err := decoder.Decode(&res)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		resources = append(resources, flattenResources(res)...)
+	}
+	return resources, nil
+}
+
+func flattenResources(res Resource) []Resource {
+	if res.Kind != "List" {
+		return []Resource{res}
+	}
+	resources := []Resource{}
+	for _, item := range res.Items {
+		resources = append(resources, flattenResources(item)...)
+	}
+	return resources
+}
+
+func intField(m map[string]interface{}, key string, def int) int {
+	if value, ok := m[key].(float64); ok {
+		return int(value)
+	}
+	return def
+}
@@ -0,0 +1,173 @@
+package kubectl
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	ready   = "ready"
+	pending = "pending"
+	failed  = "failed"
+)
+
+type readinessCase struct {
+	name   string
+	object string
+	want   string
+}
+
+// Returns whether the objects of the get output are ready, still pending, or
+// failed for good
+func readinessOf(t *testing.T, out string) string {
+	resources, err := decodeResources([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checkAll(resources, "")
+	if _, ok := err.(permanentError); ok {
+		return failed
+	} else if err != nil {
+		return pending
+	}
+	return ready
+}
+
+func runReadinessCases(t *testing.T, cases []readinessCase) {
+	for _, tc := range cases {
+		if got := readinessOf(t, tc.object); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDeploymentReadiness(t *testing.T) {
+	runReadinessCases(t, []readinessCase{
+		{"rolled out", `{"kind": "Deployment", "metadata": {"generation": 2}, "spec": {"replicas": 2},
+			"status": {"observedGeneration": 2, "updatedReplicas": 2, "availableReplicas": 2}}`, ready},
+		{"generation not observed", `{"kind": "Deployment", "metadata": {"generation": 3}, "spec": {"replicas": 2},
+			"status": {"observedGeneration": 2, "updatedReplicas": 2, "availableReplicas": 2}}`, pending},
+		{"old replicas available", `{"kind": "Deployment", "metadata": {"generation": 2}, "spec": {"replicas": 2},
+			"status": {"observedGeneration": 2, "updatedReplicas": 1, "availableReplicas": 2}}`, pending},
+		{"not available", `{"kind": "Deployment", "metadata": {"generation": 2}, "spec": {"replicas": 2},
+			"status": {"observedGeneration": 2, "updatedReplicas": 2, "availableReplicas": 1}}`, pending},
+		{"deadline exceeded", `{"kind": "Deployment", "metadata": {"generation": 2}, "spec": {"replicas": 2},
+			"status": {"observedGeneration": 2, "updatedReplicas": 1, "availableReplicas": 1,
+				"conditions": [{"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded", "message": "old rollout"}]}}`, failed},
+		// The condition is left over from the rollout of generation 2, which the
+		// apply of generation 3 fixes
+		{"stale deadline exceeded", `{"kind": "Deployment", "metadata": {"generation": 3}, "spec": {"replicas": 2},
+			"status": {"observedGeneration": 2, "updatedReplicas": 1, "availableReplicas": 1,
+				"conditions": [{"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded", "message": "old rollout"}]}}`, pending},
+	})
+}
+
+func TestReadinessPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		readiness Readiness
+		err       string
+	}{
+		{Readiness{}, ""},
+		{Readiness{Interval: "1s", Timeout: "1m", Backoff: 1.5}, ""},
+		{Readiness{Backoff: 1}, ""},
+		{Readiness{Interval: "0s"}, "interval: must be positive"},
+		{Readiness{Interval: "soon"}, "Invalid readiness interval"},
+		{Readiness{Timeout: "0s"}, "timeout: must be positive"},
+		{Readiness{Timeout: "-1m"}, "timeout: must be positive"},
+		{Readiness{Backoff: 0.5}, "backoff: must be at least 1"},
+		{Readiness{Backoff: -2}, "backoff: must be at least 1"},
+		{Readiness{Condition: ".status["}, "Invalid condition"},
+	} {
+		err := tc.readiness.Validate()
+		if tc.err == "" && err != nil {
+			t.Errorf("%+v: got %v, want no error", tc.readiness, err)
+		} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%+v: got %v, want %q", tc.readiness, err, tc.err)
+		}
+	}
+}
+
+func TestStatefulSetReadiness(t *testing.T) {
+	runReadinessCases(t, []readinessCase{
+		{"rolled out", `{"kind": "StatefulSet", "metadata": {"generation": 2}, "spec": {"replicas": 3},
+			"status": {"observedGeneration": 2, "readyReplicas": 3, "currentRevision": "db-2", "updateRevision": "db-2"}}`, ready},
+		{"generation not observed", `{"kind": "StatefulSet", "metadata": {"generation": 3}, "spec": {"replicas": 3},
+			"status": {"observedGeneration": 2, "readyReplicas": 3, "currentRevision": "db-2", "updateRevision": "db-2"}}`, pending},
+		{"not ready", `{"kind": "StatefulSet", "metadata": {"generation": 2}, "spec": {"replicas": 3},
+			"status": {"observedGeneration": 2, "readyReplicas": 2, "currentRevision": "db-2", "updateRevision": "db-2"}}`, pending},
+		{"revision rolling out", `{"kind": "StatefulSet", "metadata": {"generation": 2}, "spec": {"replicas": 3},
+			"status": {"observedGeneration": 2, "readyReplicas": 3, "currentRevision": "db-1", "updateRevision": "db-2"}}`, pending},
+		// A StatefulSet has no failed state, crash-looping pods are only pending
+		{"no pods ready", `{"kind": "StatefulSet", "metadata": {"generation": 2}, "spec": {"replicas": 3},
+			"status": {"observedGeneration": 2, "readyReplicas": 0}}`, pending},
+	})
+}
+
+func TestDaemonSetReadiness(t *testing.T) {
+	runReadinessCases(t, []readinessCase{
+		{"rolled out", `{"kind": "DaemonSet", "metadata": {"generation": 1},
+			"status": {"observedGeneration": 1, "desiredNumberScheduled": 3, "updatedNumberScheduled": 3, "numberReady": 3}}`, ready},
+		{"just created", `{"kind": "DaemonSet", "metadata": {"generation": 1}, "status": {}}`, pending},
+		{"not updated", `{"kind": "DaemonSet", "metadata": {"generation": 2},
+			"status": {"observedGeneration": 2, "desiredNumberScheduled": 3, "updatedNumberScheduled": 1, "numberReady": 3}}`, pending},
+		{"no pods ready", `{"kind": "DaemonSet", "metadata": {"generation": 2},
+			"status": {"observedGeneration": 2, "desiredNumberScheduled": 3, "updatedNumberScheduled": 3, "numberReady": 0}}`, pending},
+	})
+}
+
+func TestJobReadiness(t *testing.T) {
+	runReadinessCases(t, []readinessCase{
+		{"complete", `{"kind": "Job", "status": {"conditions": [{"type": "Complete", "status": "True"}]}}`, ready},
+		{"enough completions", `{"kind": "Job", "spec": {"completions": 2}, "status": {"succeeded": 2}}`, ready},
+		{"running", `{"kind": "Job", "spec": {"completions": 2}, "status": {"active": 1, "succeeded": 1}}`, pending},
+		{"failed", `{"kind": "Job", "status": {"conditions": [{"type": "Failed", "status": "True", "message": "BackoffLimitExceeded"}]}}`, failed},
+	})
+}
+
+func TestPersistentVolumeClaimReadiness(t *testing.T) {
+	runReadinessCases(t, []readinessCase{
+		{"bound", `{"kind": "PersistentVolumeClaim", "status": {"phase": "Bound"}}`, ready},
+		{"pending", `{"kind": "PersistentVolumeClaim", "status": {"phase": "Pending"}}`, pending},
+		{"no status", `{"kind": "PersistentVolumeClaim"}`, pending},
+		{"lost", `{"kind": "PersistentVolumeClaim", "status": {"phase": "Lost"}}`, failed},
+	})
+}
+
+func TestPodReadiness(t *testing.T) {
+	runReadinessCases(t, []readinessCase{
+		{"ready", `{"kind": "Pod", "status": {"phase": "Running", "conditions": [{"type": "Ready", "status": "True"}]}}`, ready},
+		{"running not ready", `{"kind": "Pod", "status": {"phase": "Running", "conditions": [{"type": "Ready", "status": "False"}]}}`, pending},
+		{"pending", `{"kind": "Pod", "status": {"phase": "Pending"}}`, pending},
+		{"failed", `{"kind": "Pod", "status": {"phase": "Failed", "message": "OOMKilled"}}`, failed},
+	})
+}
+
+func TestLoadBalancerReadiness(t *testing.T) {
+	runReadinessCases(t, []readinessCase{
+		{"cluster IP service", `{"kind": "Service", "spec": {"type": "ClusterIP"}}`, ready},
+		{"load balancer assigned", `{"kind": "Service", "spec": {"type": "LoadBalancer"},
+			"status": {"loadBalancer": {"ingress": [{"ip": "10.0.0.1"}]}}}`, ready},
+		{"load balancer pending", `{"kind": "Service", "spec": {"type": "LoadBalancer"}, "status": {"loadBalancer": {}}}`, pending},
+		{"ingress assigned", `{"kind": "Ingress", "status": {"loadBalancer": {"ingress": [{"hostname": "lb.example.com"}]}}}`, ready},
+		{"ingress pending", `{"kind": "Ingress", "status": {}}`, pending},
+		// Neither has a failed state, a load balancer may be assigned late
+		{"ingress without status", `{"kind": "Ingress"}`, pending},
+	})
+}
+
+func TestListReadiness(t *testing.T) {
+	const (
+		readyPod   = `{"kind": "Pod", "status": {"conditions": [{"type": "Ready", "status": "True"}]}}`
+		pendingPod = `{"kind": "Pod", "status": {"phase": "Pending"}}`
+		failedJob  = `{"kind": "Job", "status": {"conditions": [{"type": "Failed", "status": "True"}]}}`
+	)
+	runReadinessCases(t, []readinessCase{
+		{"all ready", `{"kind": "List", "items": [` + readyPod + `, {"kind": "ConfigMap"}]}`, ready},
+		{"nested lists", `{"kind": "List", "items": [{"kind": "List", "items": [` + readyPod + `]}, ` + readyPod + `]}`, ready},
+		{"one pending", `{"kind": "List", "items": [` + readyPod + `, ` + pendingPod + `]}`, pending},
+		{"nested pending", `{"kind": "List", "items": [{"kind": "List", "items": [` + pendingPod + `]}]}`, pending},
+		{"one failed", `{"kind": "List", "items": [` + pendingPod + `, ` + failedJob + `]}`, failed},
+		{"objects one after the other", readyPod + "\n" + failedJob, failed},
+		{"empty list", `{"kind": "List", "items": []}`, ready},
+	})
+}
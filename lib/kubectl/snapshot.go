@@ -0,0 +1,135 @@
+package kubectl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/golang/glog"
+)
+
+const (
+	lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+)
+
+// Snapshot is the live state of the objects of a manifest, recorded right
+// before the manifest is applied so that the apply can be undone. Previous
+// holds the objects that were already in the cluster, and Created the objects
+// of the manifest that were not.
+type Snapshot struct {
+	FilePath string
+	Previous []map[string]interface{}
+	Created  []map[string]interface{}
+}
+
+// Records the live state of every object of the file, one object at a time so
+// that the ones missing from the cluster can be told apart
+func TakeSnapshot(ctx context.Context, client Client, filePath string) (*Snapshot, error) {
+	objects, err := ReadObjects(filePath)
+	if err != nil {
+		return nil, err
+	}
+	tmpDir, err := ioutil.TempDir("", "kubemgr-snapshot")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := Snapshot{FilePath: filePath}
+	for i, obj := range objects {
+		objPath := path.Join(tmpDir, fmt.Sprintf("%d.json", i))
+		err = WriteObjects(objPath, []map[string]interface{}{obj})
+		if err != nil {
+			return nil, err
+		}
+		exists, err := client.Exists(ctx, objPath)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			s.Created = append(s.Created, obj)
+			continue
+		}
+		out, err := client.Get(ctx, objPath)
+		if err != nil {
+			return nil, err
+		}
+		live := make(map[string]interface{})
+		err = json.Unmarshal(out, &live)
+		if err != nil {
+			return nil, err
+		}
+		s.Previous = append(s.Previous, restorable(live))
+	}
+	glog.V(2).Infof("%sRecorded %d existing and %d new objects of '%s'", LogPrefix(ctx), len(s.Previous), len(s.Created), filePath)
+	return &s, nil
+}
+
+// Puts the objects back the way they were: the previous objects are applied
+// again and the created ones are deleted
+func (s *Snapshot) Restore(ctx context.Context, client Client) error {
+	prefix := LogPrefix(ctx)
+	tmpDir, err := ioutil.TempDir("", "kubemgr-restore")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if len(s.Previous) > 0 {
+		previousPath := path.Join(tmpDir, "previous.json")
+		err = WriteObjects(previousPath, s.Previous)
+		if err != nil {
+			return err
+		}
+		glog.Infof("%sRestoring %d previous objects of '%s'", prefix, len(s.Previous), s.FilePath)
+		err = client.Apply(ctx, previousPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, obj := range s.Created {
+		objPath := path.Join(tmpDir, fmt.Sprintf("created-%d.json", i))
+		err = WriteObjects(objPath, []map[string]interface{}{obj})
+		if err != nil {
+			return err
+		}
+		exists, err := client.Exists(ctx, objPath)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		glog.Infof("%sDeleting %s created by '%s'", prefix, ObjectKey(obj), s.FilePath)
+		err = client.Delete(ctx, objPath)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+// Strips the fields of a live object that cannot be applied back as they are
+func restorable(obj map[string]interface{}) map[string]interface{} {
+	ret := withoutServerFields(obj)
+	metadata, _ := ret["metadata"].(map[string]interface{})
+	annotations, ok := metadata["annotations"].(map[string]interface{})
+	if !ok {
+		return ret
+	}
+	kept := make(map[string]interface{})
+	for k, v := range annotations {
+		if k != lastAppliedAnnotation {
+			kept[k] = v
+		}
+	}
+	metadata["annotations"] = kept
+	return ret
+}
@@ -1,22 +1,76 @@
 package kubemgr
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"flag"
+	"fmt"
 	"os"
 	"path"
+	"time"
 
 	"github.com/apourchet/kubemgr/lib/kubectl"
 	"github.com/golang/glog"
 )
 
+var (
+	DryRun    DryRunMode
+	Backend   string
+	FakeState string
+)
+
+func init() {
+	flag.Var(&DryRun, "dry-run", "Only show what would be sent to the cluster: 'client' when given alone, 'server' or 'none'")
+	flag.StringVar(&Backend, "backend", kubectl.BackendKubectl, "Cluster backend to use: 'kubectl', 'native' or 'fake'")
+	flag.StringVar(&FakeState, "fake-state", "", "File in which the fake backend keeps its objects between runs")
+}
+
+// DryRunMode is the value of --dry-run. It is a boolean flag, so that
+// "--dry-run apply" means a client dry run of the apply instead of a dry run
+// in the "apply" mode.
+type DryRunMode string
+
+func (m *DryRunMode) String() string {
+	if m == nil {
+		return ""
+	}
+	return string(*m)
+}
+
+func (m *DryRunMode) Set(value string) error {
+	switch value {
+	case "true":
+		value = kubectl.DryRunClient
+	case "false":
+		value = ""
+	}
+	err := kubectl.CheckDryRun(value)
+	if err != nil {
+		return err
+	}
+	*m = DryRunMode(value)
+	return nil
+}
+
+func (m *DryRunMode) IsBoolFlag() bool {
+	return true
+}
+
 type KubeMgr struct {
 	filePath string
 }
 
-type PackagedContext struct {
-	Package string
-	Context string
+var (
+	DefaultConfigFiles = []string{"kubeconfig.json", "kubeconfig.yaml", "kubeconfig.yml"}
+)
+
+// Returns the first of the default configuration files that exists in the
+// current directory
+func DefaultConfigFile() string {
+	for _, filePath := range DefaultConfigFiles {
+		if exists, _ := fileExists(filePath); exists {
+			return filePath
+		}
+	}
+	return DefaultConfigFiles[0]
 }
 
 func NewKubeMgr(filePath string) *KubeMgr {
@@ -28,40 +82,18 @@ func NewKubeMgr(filePath string) *KubeMgr {
 func (k *KubeMgr) Do(action string, target string) {
 	os.Chdir(path.Dir(k.filePath))
 	filePath := path.Base(k.filePath)
-	importManager := NewImportManager()
-	resourceManager := NewResourceManager()
-	injector := NewInjector()
-
-	// Get and close the import loops
-	origImports, err := importManager.GetImports(filePath)
-	Fatal(err)
-	glog.V(3).Infof("Got first level imports: \n   %v", origImports)
-
-	allImports, err := importManager.GetImportClosure(origImports)
-	Fatal(err)
-	glog.V(3).Infof("Got closed imports: \n   %v", allImports)
-
-	// Get resources from current config
-	err = resourceManager.FetchResources(filePath)
-	Fatal(err)
-	glog.V(3).Infof("Got resources: \n%s", resourceManager.String())
-
-	// Get all resources from the imports
-	err = resourceManager.GetImportedResources(allImports)
-	Fatal(err)
-	glog.V(3).Infof("Got imported resources: \n%s", resourceManager.String())
-
-	// Prepare injector
-	err = injector.GetInjects(allImports)
-	Fatal(err)
-	glog.V(3).Infof("Got imported injects: \n%s", injector.String())
+	if action == ActionValidate {
+		Fatal(k.Validate())
+		glog.V(1).Infof("Done!")
+		return
+	}
+	if action == ActionDeps {
+		Fatal(k.UpdateDeps(target))
+		glog.V(1).Infof("Done!")
+		return
+	}
 
-	err = injector.GetInjects([]string{filePath})
-	Fatal(err)
-	glog.V(3).Infof("Got injects: \n%s", injector.String())
-
-	// Set the injector on the resourceManager
-	err = resourceManager.SetInjector(injector)
+	resourceManager, err := k.loadResources(filePath)
 	Fatal(err)
 
 	// Check for cyclic dependencies
@@ -74,12 +106,35 @@ func (k *KubeMgr) Do(action string, target string) {
 	kubectl.Context, err = k.GetContext()
 	Fatal(err)
 
+	kubectl.DryRun = string(DryRun)
+
+	// Set the cluster client on the resourceManager
+	kubectl.FakeState = FakeState
+	client, err := kubectl.NewClient(Backend)
+	Fatal(err)
+	err = resourceManager.SetClient(client)
+	Fatal(err)
+
+	pkg, err := k.GetPackage()
+	Fatal(err)
+	releaseStore := NewReleaseStore(Backend, client, pkg)
+
 	switch action {
 	case ActionInject:
 		err = resourceManager.PrepResources(target)
 		break
+	case ActionPlan:
+		err = resourceManager.PlanResources(target)
+		break
+	case ActionDiff:
+		err = resourceManager.DiffResources(target)
+		break
 	case ActionApply:
 		err = resourceManager.ApplyResources(target)
+		if err != nil {
+			break
+		}
+		k.recordRelease(releaseStore, resourceManager, ActionApply, target)
 		break
 	case ActionCheck:
 		err = resourceManager.CheckResources(target)
@@ -93,6 +148,16 @@ func (k *KubeMgr) Do(action string, target string) {
 			break
 		}
 		err = resourceManager.ApplyResources(target)
+		if err != nil {
+			break
+		}
+		k.recordRelease(releaseStore, resourceManager, ActionRecreate, target)
+		break
+	case ActionHistory:
+		err = k.history(releaseStore)
+		break
+	case ActionRollback:
+		err = k.rollback(releaseStore, resourceManager, target)
 		break
 	}
 
@@ -101,15 +166,135 @@ func (k *KubeMgr) Do(action string, target string) {
 }
 
 func (k *KubeMgr) GetContext() (string, error) {
-	filePath := path.Base(k.filePath)
-	configBytes, err := ioutil.ReadFile(filePath)
+	config, err := LoadConfig(path.Base(k.filePath))
 	if err != nil {
 		return "", err
 	}
-	pkg := PackagedContext{}
-	err = json.Unmarshal(configBytes, &pkg)
+	return config.Context, nil
+}
+
+func (k *KubeMgr) GetPackage() (string, error) {
+	config, err := LoadConfig(path.Base(k.filePath))
 	if err != nil {
 		return "", err
 	}
-	return pkg.Context, nil
+	return config.Package, nil
+}
+
+// Gathers the resources and the injects of the configuration and of all its
+// imports
+func (k *KubeMgr) loadResources(filePath string) (ResourceManagerInterface, error) {
+	importManager := NewImportManager()
+	resourceManager := NewResourceManager()
+	injector := NewInjector()
+
+	// Get and close the import loops
+	origImports, err := importManager.GetImports(filePath)
+	if err != nil {
+		return nil, err
+	}
+	glog.V(3).Infof("Got first level imports: \n   %v", origImports)
+
+	allImports, err := importManager.GetImportClosure(origImports)
+	if err != nil {
+		return nil, err
+	}
+	glog.V(3).Infof("Got closed imports: \n   %v", allImports)
+
+	err = importManager.CheckLock(allImports)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get resources from current config
+	err = resourceManager.FetchResources(filePath)
+	if err != nil {
+		return nil, err
+	}
+	glog.V(3).Infof("Got resources: \n%s", resourceManager.String())
+
+	// Get all resources from the imports
+	err = resourceManager.GetImportedResources(allImports)
+	if err != nil {
+		return nil, err
+	}
+	glog.V(3).Infof("Got imported resources: \n%s", resourceManager.String())
+
+	// Prepare injector
+	err = injector.GetInjects(allImports)
+	if err != nil {
+		return nil, err
+	}
+	glog.V(3).Infof("Got imported injects: \n%s", injector.String())
+
+	err = injector.GetInjects([]string{filePath})
+	if err != nil {
+		return nil, err
+	}
+	glog.V(3).Infof("Got injects: \n%s", injector.String())
+
+	// Set the injector on the resourceManager
+	err = resourceManager.SetInjector(injector)
+	return resourceManager, err
+}
+
+// Saves what the action on the target deployed as a new release. The apply
+// went through by then, so failing to record it is only warned about.
+func (k *KubeMgr) recordRelease(store ReleaseStore, resourceManager ResourceManagerInterface, action, target string) {
+	if kubectl.DryRun != "" {
+		glog.Infof("Dry run: not recording the release")
+		return
+	}
+	release, err := resourceManager.Release(target)
+	if err == nil {
+		release.Description = action + " " + target
+		err = store.Save(release)
+	}
+	if err != nil {
+		glog.Warningf("The %s of %s went through but recording the release failed: %v", action, target, err)
+		return
+	}
+	glog.V(1).Infof("Recorded release %d", release.Revision)
+}
+
+func (k *KubeMgr) history(store ReleaseStore) error {
+	releases, err := store.List()
+	if err != nil {
+		return err
+	}
+	printHistory(releases)
+	return nil
+}
+
+// Applies the manifests of a stored revision again, recording the result as
+// a new release
+func (k *KubeMgr) rollback(store ReleaseStore, resourceManager ResourceManagerInterface, target string) error {
+	revision, err := parseRevision(target)
+	if err != nil {
+		return err
+	}
+	release, err := store.Get(revision)
+	if err != nil {
+		return err
+	}
+	glog.V(1).Infof("Rolling back to revision %d (%s)", release.Revision, release.Description)
+	err = resourceManager.ApplyRelease(release)
+	if err != nil {
+		return err
+	}
+	if kubectl.DryRun != "" {
+		glog.Infof("Dry run: not recording the release")
+		return nil
+	}
+
+	rolledBack := *release
+	rolledBack.Time = time.Now().UTC()
+	rolledBack.Description = fmt.Sprintf("rollback to %d", revision)
+	err = store.Save(&rolledBack)
+	if err != nil {
+		glog.Warningf("The rollback to %d went through but recording the release failed: %v", revision, err)
+		return nil
+	}
+	glog.V(1).Infof("Recorded release %d", rolledBack.Revision)
+	return nil
 }
@@ -0,0 +1,139 @@
+package kubemgr
+
+import (
+	"flag"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/apourchet/kubemgr/lib/kubectl"
+)
+
+func TestDryRunFlag(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		mode DryRunMode
+		rest string
+		err  string
+	}{
+		{[]string{"apply", "app-dp"}, "", "apply app-dp", ""},
+		{[]string{"--dry-run", "apply", "app-dp"}, kubectl.DryRunClient, "apply app-dp", ""},
+		{[]string{"--dry-run=server", "apply", "app-dp"}, kubectl.DryRunServer, "apply app-dp", ""},
+		{[]string{"--dry-run=none", "plan", "*"}, kubectl.DryRunNone, "plan *", ""},
+		{[]string{"--dry-run=false", "apply", "app-dp"}, "", "apply app-dp", ""},
+		{[]string{"--dry-run=all", "apply", "app-dp"}, "", "", "Unknown dry-run mode 'all'"},
+	} {
+		var mode DryRunMode
+		flags := flag.NewFlagSet("kubemgr", flag.ContinueOnError)
+		flags.SetOutput(ioutil.Discard)
+		flags.Var(&mode, "dry-run", "")
+		err := flags.Parse(tc.args)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%v: got %v, want %q", tc.args, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tc.args, err)
+			continue
+		}
+		if mode != tc.mode || strings.Join(flags.Args(), " ") != tc.rest {
+			t.Errorf("%v: got mode %q and arguments %v, want %q and %s", tc.args, mode, flags.Args(), tc.mode, tc.rest)
+		}
+	}
+}
+
+func TestDryRunLeavesTheClusterUntouched(t *testing.T) {
+	for _, mode := range []string{kubectl.DryRunClient, kubectl.DryRunServer} {
+		cluster := newShop(t)
+		previous := kubectl.DryRun
+		kubectl.DryRun = mode
+		err := loadShop(t, cluster).ApplyResources("*")
+		kubectl.DryRun = previous
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		// Every resource still goes through the client, which changes nothing
+		if len(cluster.applied) != 4 {
+			t.Errorf("%s: got %v sent to the cluster, want the 4 resources", mode, cluster.applied)
+		}
+		if len(cluster.Objects) != 0 {
+			t.Errorf("%s: got objects %v, want none", mode, cluster.Objects)
+		}
+	}
+}
+
+var yamlPackage = map[string]string{
+	"kubeconfig.yaml": `package: shop
+injects:
+  - name: mine
+    path: injects.yaml
+resources:
+  db:
+    path: k8s/db.yaml
+`,
+	"injects.yaml": `namespace: shop
+db:
+  name: db
+  port: 5432
+`,
+	"k8s/db.yaml": `# The database of the shop
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .db.name }}-config
+  namespace: {{ .namespace }}
+data:
+  banner: "---"
+  init.sh: |
+    echo start
+    ---
+    echo {{ .shop_mine.db.port }}
+---
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .db.name }}
+  namespace: {{ .namespace }}
+spec:
+  ports:
+    - port: {{ .db.port }}
+`,
+}
+
+func TestYAMLPackage(t *testing.T) {
+	inPackageDir(t, yamlPackage)
+	if configFile := DefaultConfigFile(); configFile != "kubeconfig.yaml" {
+		t.Fatalf("Got configuration file %s, want kubeconfig.yaml", configFile)
+	}
+	resourceManager, err := NewKubeMgr("kubeconfig.yaml").loadResources("kubeconfig.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = resourceManager.AssertValid()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster := &recordingCluster{FakeCluster: kubectl.NewFakeCluster()}
+	resourceManager.SetClient(cluster)
+	err = resourceManager.ApplyResources("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cluster.Objects) != 2 {
+		t.Errorf("Got objects %v, want the config map and the service", cluster.Objects)
+	}
+	data, _ := cluster.Objects["ConfigMap/shop/db-config"]["data"].(map[string]interface{})
+	if data["banner"] != "---" || data["init.sh"] != "echo start\n---\necho 5432\n" {
+		t.Errorf("Got config map data %v", data)
+	}
+	spec, _ := cluster.Objects["Service/shop/db"]["spec"].(map[string]interface{})
+	ports, _ := spec["ports"].([]interface{})
+	if len(ports) != 1 || ports[0].(map[string]interface{})["port"] != 5432.0 {
+		t.Errorf("Got service ports %v, want 5432", ports)
+	}
+}
@@ -0,0 +1,245 @@
+package kubemgr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+)
+
+// LockFile records what the imports of the configuration next to it resolved
+// to, so that every run imports the same thing
+const LockFile = "kubemgr.lock"
+
+// DepsUpdate is the command of the deps action that updates the lock file
+const DepsUpdate = "update"
+
+type Lock struct {
+	Git     []GitLock    `json:"git"`
+	Imports []ImportLock `json:"imports"`
+}
+
+// GitLock is the commit that the ref of a git import resolved to
+type GitLock struct {
+	URL    string `json:"url"`
+	Ref    string `json:"ref"`
+	Commit string `json:"commit"`
+}
+
+// ImportLock is a configuration of the import closure along with the digest
+// of its package. Path is the URL of the configuration, its path in the git
+// repository, or else its local path.
+type ImportLock struct {
+	Path   string `json:"path"`
+	Git    string `json:"git,omitempty"`
+	Commit string `json:"commit,omitempty"`
+	Sha256 string `json:"sha256"`
+}
+
+var (
+	Frozen bool
+)
+
+func init() {
+	flag.BoolVar(&Frozen, "frozen", false, "Fail instead of updating "+LockFile+" when the resolved imports differ from it")
+}
+
+// Reads the lock file, which is empty when the file does not exist yet
+func LoadLock(filePath string) (*Lock, error) {
+	lock := Lock{}
+	exists, err := fileExists(filePath)
+	if err != nil || !exists {
+		return &lock, err
+	}
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &lock)
+	return &lock, err
+}
+
+// Writes the lock file, unless it is already up to date
+func (l *Lock) Save(filePath string) error {
+	if l.Git == nil {
+		l.Git = []GitLock{}
+	}
+	if l.Imports == nil {
+		l.Imports = []ImportLock{}
+	}
+	sort.Slice(l.Git, func(i, j int) bool {
+		if l.Git[i].URL != l.Git[j].URL {
+			return l.Git[i].URL < l.Git[j].URL
+		}
+		return l.Git[i].Ref < l.Git[j].Ref
+	})
+	sort.Slice(l.Imports, func(i, j int) bool {
+		return l.Imports[i].String() < l.Imports[j].String()
+	})
+	content, err := json.MarshalIndent(l, "", "    ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+	previous, err := ioutil.ReadFile(filePath)
+	if err == nil && bytes.Equal(previous, content) {
+		return nil
+	}
+	return ioutil.WriteFile(filePath, content, 0644)
+}
+
+// Returns the commit that the ref of the repository is locked to, if any
+func (l *Lock) GitCommit(gitURL, ref string) string {
+	for _, locked := range l.Git {
+		if locked.URL == gitURL && locked.Ref == ref {
+			return locked.Commit
+		}
+	}
+	return ""
+}
+
+// Whether any of the imports comes from a git repository or from a URL, which
+// may change between runs
+func (l *Lock) isRemote() bool {
+	if len(l.Git) > 0 {
+		return true
+	}
+	for _, locked := range l.Imports {
+		if isURL(locked.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Lock) AddGit(gitURL, ref, commit string) {
+	if l.GitCommit(gitURL, ref) == "" {
+		l.Git = append(l.Git, GitLock{URL: gitURL, Ref: ref, Commit: commit})
+	}
+}
+
+// Lists the entries that are only in this lock with a "-" and the ones that are
+// only in the other with a "+"
+func (l *Lock) Diff(other *Lock) []string {
+	diff := []string{}
+	before, after := l.entries(), other.entries()
+	for _, entry := range before {
+		if !containsString(after, entry) {
+			diff = append(diff, "- "+entry)
+		}
+	}
+	for _, entry := range after {
+		if !containsString(before, entry) {
+			diff = append(diff, "+ "+entry)
+		}
+	}
+	return diff
+}
+
+func (l *Lock) entries() []string {
+	entries := []string{}
+	for _, locked := range l.Git {
+		entries = append(entries, locked.String())
+	}
+	for _, locked := range l.Imports {
+		entries = append(entries, fmt.Sprintf("%s (sha256 %s)", locked, locked.Sha256))
+	}
+	return entries
+}
+
+func (g GitLock) String() string {
+	return fmt.Sprintf("git %s at %s: %s", g.URL, g.Ref, g.Commit)
+}
+
+func (i ImportLock) String() string {
+	if i.Git != "" {
+		return fmt.Sprintf("%s in %s at %s", i.Path, i.Git, i.Commit)
+	}
+	return i.Path
+}
+
+// Resolves the imports again, fetching every git repository and downloading
+// every URL, and records them in the lock file
+func (k *KubeMgr) UpdateDeps(command string) error {
+	if command != DepsUpdate {
+		return fmt.Errorf("Unknown deps command '%s', want '%s'", command, DepsUpdate)
+	}
+	if Frozen {
+		return fmt.Errorf("Cannot update %s with --frozen", LockFile)
+	}
+	RefreshImports = true
+
+	// Starting from an empty lock resolves every git ref again
+	importManager := &ImportManager{Sources: make(map[string]ImportLock), Lock: &Lock{}, Resolved: &Lock{}}
+	imports, err := importManager.GetImports(path.Base(k.filePath))
+	if err != nil {
+		return err
+	}
+	imports, err = importManager.GetImportClosure(imports)
+	if err != nil {
+		return err
+	}
+	err = importManager.SaveLock(imports)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Locked %d import(s) and %d git ref(s) in %s\n", len(importManager.Resolved.Imports), len(importManager.Resolved.Git), LockFile)
+	return nil
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+// Returns the files of the package: its configuration, the templates of its
+// resources and its inject files, relative to the configuration's directory
+func packageFiles(configPath string, config *Config) []string {
+	files := []string{path.Base(configPath)}
+	for _, res := range config.Resources {
+		files = append(files, path.Clean(res.Path))
+	}
+	for _, inject := range config.Injects {
+		files = append(files, path.Clean(inject.Path))
+	}
+	sort.Strings(files)
+	unique := []string{}
+	for _, file := range files {
+		if len(unique) == 0 || unique[len(unique)-1] != file {
+			unique = append(unique, file)
+		}
+	}
+	return unique
+}
+
+// Returns the sha256 of what sha256sum prints for the files of the package.
+// Missing files are left out, they are reported when the resources are loaded.
+func packageDigest(configPath string) (string, error) {
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return "", err
+	}
+	listing := ""
+	for _, file := range packageFiles(configPath, config) {
+		content, err := ioutil.ReadFile(path.Join(path.Dir(configPath), file))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		listing += fmt.Sprintf("%x  %s\n", sha256.Sum256(content), file)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(listing))), nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
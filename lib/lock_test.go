@@ -0,0 +1,209 @@
+package kubemgr
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useFrozen(t *testing.T, frozen bool) {
+	previous := Frozen
+	Frozen = frozen
+	t.Cleanup(func() { Frozen = previous })
+}
+
+// Moves to a temporary directory holding the files. The configurations
+// cached under the same relative paths are forgotten.
+func inPackageDir(t *testing.T, files map[string]string) {
+	dir := t.TempDir()
+	for file, content := range files {
+		forgetConfig(file)
+		err := os.MkdirAll(path.Dir(path.Join(dir, file)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path.Join(dir, file), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func modTime(t *testing.T, filePath string) time.Time {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.ModTime()
+}
+
+// Resolves the import closure of the configuration of the current directory
+// and checks it against the lock file
+func checkLock(t *testing.T) error {
+	importManager := NewImportManager()
+	imports, err := importManager.GetImports("kubeconfig.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports, err = importManager.GetImportClosure(imports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return importManager.CheckLock(imports)
+}
+
+func saveLock(t *testing.T) {
+	importManager := NewImportManager()
+	imports, err := importManager.GetImports("kubeconfig.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports, err = importManager.GetImportClosure(imports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = importManager.SaveLock(imports)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var localImportPackage = map[string]string{
+	"kubeconfig.json":        `{"imports": [{"path": "syslog/kubeconfig.json"}]}`,
+	"syslog/kubeconfig.json": `{"package": "syslog", "resources": {"syslog-svc": {"path": "svc.json"}}}`,
+	"syslog/svc.json":        `{"kind": "Service"}`,
+}
+
+func TestLockDiff(t *testing.T) {
+	before := &Lock{
+		Git:     []GitLock{{URL: "https://example.com/infra.git", Ref: "v1", Commit: "aaa"}},
+		Imports: []ImportLock{{Path: "syslog/kubeconfig.json", Sha256: "111"}, {Path: "db/kubeconfig.json", Sha256: "222"}},
+	}
+	after := &Lock{
+		Git:     []GitLock{{URL: "https://example.com/infra.git", Ref: "v1", Commit: "bbb"}},
+		Imports: []ImportLock{{Path: "syslog/kubeconfig.json", Sha256: "111"}, {Path: "db/kubeconfig.json", Sha256: "333"}},
+	}
+	if diff := before.Diff(before); len(diff) != 0 {
+		t.Errorf("Got %v between a lock and itself", diff)
+	}
+	want := []string{
+		"- git https://example.com/infra.git at v1: aaa",
+		"- db/kubeconfig.json (sha256 222)",
+		"+ git https://example.com/infra.git at v1: bbb",
+		"+ db/kubeconfig.json (sha256 333)",
+	}
+	if diff := before.Diff(after); strings.Join(diff, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got diff %v, want %v", diff, want)
+	}
+}
+
+func TestLockSave(t *testing.T) {
+	filePath := path.Join(t.TempDir(), LockFile)
+	lock := &Lock{Imports: []ImportLock{
+		{Path: "syslog/kubeconfig.json", Sha256: "111"},
+		{Path: "kubeconfig.json", Git: "https://example.com/infra.git", Commit: "aaa", Sha256: "222"},
+	}}
+	err := lock.Save(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"git": []`, `"imports": [`, `"path": "kubeconfig.json"`, `"commit": "aaa"`, `"sha256": "111"`} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("%s is missing from the lock file:\n%s", want, content)
+		}
+	}
+	if strings.Index(string(content), `"commit": "aaa"`) > strings.Index(string(content), `"sha256": "111"`) {
+		t.Errorf("Imports are not sorted:\n%s", content)
+	}
+	if strings.Count(string(content), `"git"`) != 2 {
+		t.Errorf("Empty git field of a local import was written:\n%s", content)
+	}
+
+	loaded, err := LoadLock(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := lock.Diff(loaded); len(diff) != 0 {
+		t.Errorf("Lock read back differs: %v", diff)
+	}
+
+	// An up to date lock file is left untouched
+	past := modTime(t, filePath).Add(-time.Hour)
+	err = os.Chtimes(filePath, past, past)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loaded.Save(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modTime(t, filePath).Equal(past) {
+		t.Error("Lock file was written again without changes")
+	}
+}
+
+func TestCheckLockWithoutLockFile(t *testing.T) {
+	useFrozen(t, true)
+
+	// Local imports do not need a lock file
+	inPackageDir(t, localImportPackage)
+	err := checkLock(t)
+	if err != nil {
+		t.Errorf("Got %v for local imports, want no error", err)
+	}
+
+	useCacheDir(t)
+	server := newPackageServer(t, syslogPackage())
+	inPackageDir(t, map[string]string{
+		"kubeconfig.json": `{"imports": [{"path": "` + server.URL + `/syslog/kubeconfig.json"}]}`,
+	})
+	err = checkLock(t)
+	if err == nil || !strings.Contains(err.Error(), "No "+LockFile) {
+		t.Errorf("Got %v for a remote import, want an error", err)
+	}
+	Frozen = false
+	err = checkLock(t)
+	if err != nil {
+		t.Errorf("Got %v without --frozen, want only a warning", err)
+	}
+}
+
+func TestCheckLockFrozenMismatch(t *testing.T) {
+	useFrozen(t, false)
+	inPackageDir(t, localImportPackage)
+	saveLock(t)
+	err := checkLock(t)
+	if err != nil {
+		t.Fatalf("Got %v against an up to date lock file", err)
+	}
+
+	err = ioutil.WriteFile("syslog/svc.json", []byte(`{"kind": "Service", "spec": {}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checkLock(t)
+	if err != nil {
+		t.Errorf("Got %v without --frozen, want only a warning", err)
+	}
+	Frozen = true
+	err = checkLock(t)
+	if err == nil || !strings.Contains(err.Error(), "Imports differ") || !strings.Contains(err.Error(), "+ syslog/kubeconfig.json") {
+		t.Errorf("Got %v with --frozen, want the difference", err)
+	}
+}
@@ -0,0 +1,171 @@
+package kubemgr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/apourchet/kubemgr/lib/kubectl"
+	"github.com/golang/glog"
+)
+
+// MetadataConfig controls the labels and annotations that the injection adds
+// to every object rendered from the resources of a package. Disable turns off
+// the standard ones and Omit leaves some of them out, Include adds optional
+// ones, while Labels and Annotations are always added.
+type MetadataConfig struct {
+	Disable     bool
+	Omit        []string
+	Include     []string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+const (
+	AnnotationChecksum   = "kubemgr.io/checksum"
+	AnnotationInjectHash = "kubemgr.io/inject-hash"
+	AnnotationRevision   = "kubemgr.io/revision"
+)
+
+var (
+	StandardMetadata = []string{
+		LabelManagedBy,
+		LabelPackage,
+		AnnotationResource,
+		AnnotationChecksum,
+	}
+
+	// OptionalMetadata change with every commit or inject change, even the ones
+	// that do not touch the resource, so they are only added when included
+	OptionalMetadata = []string{
+		AnnotationInjectHash,
+		AnnotationRevision,
+	}
+
+	labelValueRegexp = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+)
+
+func (c MetadataConfig) Validate() error {
+	for _, key := range c.Omit {
+		if !containsString(StandardMetadata, key) {
+			return fmt.Errorf("Cannot omit '%s': expected one of %v", key, StandardMetadata)
+		}
+	}
+	for _, key := range c.Include {
+		if !containsString(OptionalMetadata, key) {
+			return fmt.Errorf("Cannot include '%s': expected one of %v", key, OptionalMetadata)
+		}
+	}
+	return nil
+}
+
+// Adds the labels and annotations of the resource's package to every object
+// of its injected manifest. The checksum is the one of the manifest as it was
+// rendered, before anything was added to it.
+func (r *ResourceManager) stampMetadata(resource Resource) error {
+	filePath := r.Injector.GetInjectedFilePath(resource.Path)
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	objects, err := kubectl.DecodeObjects(content)
+	if err != nil {
+		return err
+	}
+
+	checksum := sha256.Sum256(content)
+	r.rendered[resource.Path] = hex.EncodeToString(checksum[:])
+
+	config := r.Metadata[resource.Package]
+	labels := make(map[string]string)
+	annotations := make(map[string]string)
+	if !config.Disable {
+		labels[LabelManagedBy] = ManagedBy
+		labels[LabelPackage] = labelValue(resource.Package)
+		annotations[AnnotationResource] = resource.Key
+		annotations[AnnotationChecksum] = r.rendered[resource.Path]
+		if containsString(config.Include, AnnotationInjectHash) {
+			annotations[AnnotationInjectHash], err = injectHash(r.Injector.GetData())
+			if err != nil {
+				return err
+			}
+		}
+		if containsString(config.Include, AnnotationRevision) {
+			annotations[AnnotationRevision] = r.gitRevision()
+		}
+		for _, key := range config.Omit {
+			delete(labels, key)
+			delete(annotations, key)
+		}
+	}
+	for k, v := range config.Labels {
+		labels[k] = v
+	}
+	for k, v := range config.Annotations {
+		annotations[k] = v
+	}
+
+	for _, obj := range objects {
+		metadata := childMap(obj, "metadata")
+		setNonEmpty(childMap(metadata, "labels"), labels)
+		setNonEmpty(childMap(metadata, "annotations"), annotations)
+	}
+	return kubectl.WriteObjects(filePath, objects)
+}
+
+// Returns the git commit the configuration is at, marked dirty when it has
+// uncommitted changes, or nothing if it is not in a git repository
+func (r *ResourceManager) gitRevision() string {
+	r.revisionOnce.Do(func() {
+		out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+		if err != nil {
+			glog.V(2).Infof("Not recording the git revision: %v", err)
+			return
+		}
+		r.revision = strings.TrimSpace(string(out))
+		// The injected files are not part of the configuration
+		exclude := ":(exclude)*" + r.Injector.GetInjectedFilePath("")
+		status, err := exec.Command("git", "status", "--porcelain", "--untracked-files=no", "--", ".", exclude).Output()
+		if err == nil && len(strings.TrimSpace(string(status))) > 0 {
+			r.revision += "-dirty"
+		}
+	})
+	return r.revision
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+// Turns the string into a valid label value: at most 63 characters out of
+// [A-Za-z0-9_.-], starting and ending with an alphanumeric character. Values
+// that have to be shortened end with a hash of the string, so that they stay
+// distinct.
+func labelValue(s string) string {
+	value := strings.Trim(labelValueRegexp.ReplaceAllString(s, "-"), "_.-")
+	if len(value) > 63 {
+		sum := sha256.Sum256([]byte(s))
+		value = strings.TrimRight(value[:54], "_.-") + "-" + hex.EncodeToString(sum[:4])
+	}
+	return value
+}
+
+func setNonEmpty(m map[string]interface{}, values map[string]string) {
+	for k, v := range values {
+		if v != "" {
+			m[k] = v
+		}
+	}
+}
+
+func childMap(m map[string]interface{}, key string) map[string]interface{} {
+	child, ok := m[key].(map[string]interface{})
+	if !ok {
+		child = make(map[string]interface{})
+		m[key] = child
+	}
+	return child
+}
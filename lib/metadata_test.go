@@ -0,0 +1,45 @@
+package kubemgr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLabelValue(t *testing.T) {
+	long := strings.Repeat("platform.", 10)
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"kubemgr_subtest", "kubemgr_subtest"},
+		{"team/app", "team-app"},
+		{"_internal.", "internal"},
+		{"", ""},
+	} {
+		if got := labelValue(tc.in); got != tc.want {
+			t.Errorf("labelValue(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	value := labelValue(long)
+	if len(value) > 63 || labelValue(long+"x") == value {
+		t.Errorf("Got %q for a long package, want at most 63 characters distinct from the ones of other packages", value)
+	}
+}
+
+func TestOptionalMetadataIsOnlyAddedWhenIncluded(t *testing.T) {
+	cluster := newShop(t)
+	err := loadShop(t, cluster).ApplyResources("cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, _ := cluster.Objects["ConfigMap/default/cache"]["metadata"].(map[string]interface{})
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	for _, key := range OptionalMetadata {
+		if _, found := annotations[key]; found {
+			t.Errorf("%s was added without being included", key)
+		}
+	}
+	if annotations[AnnotationChecksum] == nil {
+		t.Errorf("Got annotations %v, want the standard ones", annotations)
+	}
+}
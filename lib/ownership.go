@@ -0,0 +1,130 @@
+package kubemgr
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/apourchet/kubemgr/lib/kubectl"
+	"github.com/golang/glog"
+)
+
+const (
+	LabelPackage       = "kubemgr.io/package"
+	LabelManagedBy     = "app.kubernetes.io/managed-by"
+	AnnotationResource = "kubemgr.io/resource"
+	ManagedBy          = "kubemgr"
+)
+
+var (
+	Prune bool
+)
+
+func init() {
+	flag.BoolVar(&Prune, "prune", false, "After applying, delete the live objects of the packages that are no longer declared")
+}
+
+// Deletes the live objects labelled with one of the configuration's packages
+// that none of its resources declares anymore. Every resource is injected
+// first so that all the declared objects are known. Objects owned by another
+// object were created by a controller (e.g. the Endpoints of a Service) and
+// are left to it.
+func (r *ResourceManager) pruneResources() error {
+	lister, ok := r.Client.(kubectl.Lister)
+	if !ok {
+		return fmt.Errorf("The cluster backend cannot list objects, which pruning needs")
+	}
+	err := r.PrepResources("*")
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	defaultNamespace, err := lister.DefaultNamespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	declared := make(map[string]bool)
+	packages := make(map[string]bool)
+	for _, resource := range r.Resources {
+		if resource.Package != "" {
+			packages[resource.Package] = true
+		}
+		objects, err := kubectl.ReadObjects(r.Injector.GetInjectedFilePath(resource.Path))
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			declared[pruneKey(obj, defaultNamespace)] = true
+		}
+	}
+	if len(packages) == 0 {
+		glog.Warningf("No package is named in the configuration, nothing to prune")
+		return nil
+	}
+
+	tmpDir, err := ioutil.TempDir("", "kubemgr-prune")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, pkg := range sortedKeys(packages) {
+		live, err := lister.List(ctx, LabelPackage+"="+labelValue(pkg))
+		if err != nil {
+			return err
+		}
+		for i, obj := range live {
+			key := kubectl.ObjectKey(obj)
+			if declared[pruneKey(obj, defaultNamespace)] || hasOwner(obj) {
+				continue
+			}
+			glog.Infof("Pruning %s of package %s", key, pkg)
+			objPath := path.Join(tmpDir, fmt.Sprintf("%s-%d.json", pkg, i))
+			err = kubectl.WriteObjects(objPath, []map[string]interface{}{obj})
+			if err != nil {
+				return err
+			}
+			err = r.Client.Delete(ctx, objPath)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+// Returns the key of the object, placing it in the namespace that the backend
+// creates it in when it names none, so that declared and live objects compare
+// equal. Cluster-scoped objects have no namespace on either side.
+func pruneKey(obj map[string]interface{}, defaultNamespace string) string {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	namespace, _ := metadata["namespace"].(string)
+	name, _ := metadata["name"].(string)
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	return fmt.Sprintf("%v/%s/%s", obj["kind"], namespace, name)
+}
+
+func hasOwner(obj map[string]interface{}) bool {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	owners, _ := metadata["ownerReferences"].([]interface{})
+	return len(owners) > 0
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
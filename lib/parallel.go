@@ -0,0 +1,130 @@
+package kubemgr
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync"
+
+	"github.com/apourchet/kubemgr/lib/kubectl"
+	"github.com/golang/glog"
+)
+
+var (
+	Parallel int
+)
+
+func init() {
+	flag.IntVar(&Parallel, "parallel", 1, "Number of independent resources to apply concurrently")
+}
+
+// Applies the steps wave by wave, running up to Parallel resources of the same
+// wave at once. The first failure cancels the work still in flight. Steps that
+// share a file are only applied once, so that no two of them race.
+func (r *ResourceManager) applyInWaves(steps []PlanStep) error {
+	steps = uniqueSteps(steps)
+	byName := make(map[string]PlanStep)
+	names := make([]string, len(steps))
+	for i, step := range steps {
+		byName[step.Resource] = step
+		names[i] = step.Resource
+	}
+
+	waves, err := r.dependencyGraph().Waves(names)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i, wave := range waves {
+		glog.V(1).Infof("Applying wave %d/%d: %v", i+1, len(waves), wave)
+		waveSteps := make([]PlanStep, len(wave))
+		for j, resourceName := range wave {
+			waveSteps[j] = byName[resourceName]
+		}
+		err = r.applyWave(ctx, cancel, waveSteps)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ResourceManager) applyWave(ctx context.Context, cancel context.CancelFunc, steps []PlanStep) error {
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	sem := make(chan struct{}, Parallel)
+
+	for _, step := range steps {
+		wg.Add(1)
+		go func(step PlanStep) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			err := r.applyStep(ctx, step)
+			if err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("Failed to apply %s: %v", step.Resource, err)
+					cancel()
+				})
+			}
+		}(step)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+func (r *ResourceManager) applyStep(ctx context.Context, step PlanStep) error {
+	if r.isApplied(step.Resource) {
+		return nil
+	}
+
+	ctx = kubectl.WithLogPrefix(ctx, "["+step.Resource+"] ")
+	err := r.runHooks(ctx, step.Resource, HookPreApply)
+	if err != nil {
+		return err
+	}
+	err = r.recordSnapshot(ctx, step)
+	if err != nil {
+		return err
+	}
+	err = r.Client.Apply(ctx, step.Path)
+	if err != nil {
+		return err
+	}
+	err = r.Client.Wait(ctx, step.Path, r.Resources[step.Resource].Readiness)
+	if err != nil {
+		return err
+	}
+	err = r.runChecks(ctx, step.Resource)
+	if err != nil {
+		return err
+	}
+	err = r.runHooks(ctx, step.Resource, HookPostApply)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	r.Applied[step.Resource] = true
+	r.mutex.Unlock()
+	return nil
+}
+
+func (r *ResourceManager) isApplied(resourceName string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, found := r.Applied[resourceName]
+	return found
+}
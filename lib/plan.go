@@ -0,0 +1,128 @@
+package kubemgr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+type PlanStep struct {
+	Resource string
+	Path     string
+	Reasons  []string
+}
+
+// Computes the ordered list of resources that an action on the pattern would
+// touch, without injecting anything or talking to the cluster.
+func (r *ResourceManager) Plan(pattern string) ([]PlanStep, error) {
+	graph := r.dependencyGraph()
+	targets := r.findMatchingResources(pattern)
+	resources := targets
+	if !SkipDeps {
+		resources = graph.Closure(targets)
+	}
+
+	order, err := graph.TopologicalOrder(resources)
+	if err != nil {
+		return nil, err
+	}
+
+	included := make(map[string]bool)
+	for _, resourceName := range order {
+		included[resourceName] = true
+	}
+	reasons := make(map[string][]string)
+	for _, resourceName := range targets {
+		reasons[resourceName] = []string{fmt.Sprintf("matches '%s'", pattern)}
+	}
+	for _, resourceName := range order {
+		for _, dep := range graph[resourceName] {
+			if included[dep] {
+				reasons[dep] = append(reasons[dep], "dependency of "+resourceName)
+			}
+		}
+	}
+
+	steps := make([]PlanStep, len(order))
+	for i, resourceName := range order {
+		steps[i] = PlanStep{
+			Resource: resourceName,
+			Path:     r.Injector.GetInjectedFilePath(r.Resources[resourceName].Path),
+			Reasons:  reasons[resourceName],
+		}
+	}
+	return uniqueSteps(steps), nil
+}
+
+func (r *ResourceManager) PlanResources(pattern string) error {
+	steps, err := r.Plan(pattern)
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		fmt.Printf("No resources match '%s'\n", pattern)
+		return nil
+	}
+	for i, step := range steps {
+		fmt.Printf("%d. %s\n", i+1, step.Resource)
+		fmt.Printf("   file:   %s\n", step.Path)
+		fmt.Printf("   reason: %s\n", strings.Join(step.Reasons, ", "))
+	}
+	return nil
+}
+
+// Prints the diff between the injected manifests and the live cluster state
+// for every planned resource, failing if any of them has drifted.
+func (r *ResourceManager) DiffResources(pattern string) error {
+	err := r.PrepResources(pattern)
+	if err != nil {
+		return err
+	}
+
+	steps, err := r.Plan(pattern)
+	if err != nil {
+		return err
+	}
+
+	drifted := []string{}
+	for _, step := range steps {
+		diff, drift, err := r.Client.Diff(context.Background(), step.Path)
+		if err != nil {
+			return err
+		}
+		if !drift {
+			glog.V(1).Infof("No drift for %s", step.Resource)
+			continue
+		}
+		drifted = append(drifted, step.Resource)
+		fmt.Printf("=== %s (%s)\n%s", step.Resource, step.Path, diff)
+	}
+
+	if len(drifted) > 0 {
+		return fmt.Errorf("Drift detected in %d resource(s): %s", len(drifted), strings.Join(drifted, ", "))
+	}
+	return nil
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+// Drops the steps whose injected file is already handled by an earlier step,
+// whose reasons are extended with theirs, so that no manifest is applied twice
+func uniqueSteps(steps []PlanStep) []PlanStep {
+	unique := []PlanStep{}
+	byPath := make(map[string]int)
+	for _, step := range steps {
+		if i, found := byPath[step.Path]; found {
+			for _, reason := range step.Reasons {
+				unique[i].Reasons = append(unique[i].Reasons, fmt.Sprintf("%s (as %s)", reason, step.Resource))
+			}
+			continue
+		}
+		byPath[step.Path] = len(unique)
+		unique = append(unique, step)
+	}
+	return unique
+}
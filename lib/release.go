@@ -0,0 +1,100 @@
+package kubemgr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"time"
+)
+
+// Release is what a successful apply deployed: the injected manifest of every
+// resource in the order it was applied, along with a hash of the inject data
+// they were rendered with.
+type Release struct {
+	Revision    int
+	Package     string
+	Time        time.Time
+	Description string
+	InjectHash  string
+	Resources   []ReleasedResource
+}
+
+type ReleasedResource struct {
+	Name     string
+	Path     string
+	Manifest string
+}
+
+var (
+	fileNameRegexp = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+)
+
+// Builds the release of the resources an apply of the pattern touched, reading
+// back their injected manifests
+func (r *ResourceManager) Release(pattern string) (*Release, error) {
+	steps, err := r.Plan(pattern)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := injectHash(r.Injector.GetData())
+	if err != nil {
+		return nil, err
+	}
+
+	release := Release{Time: time.Now().UTC(), InjectHash: hash}
+	for _, step := range steps {
+		content, err := ioutil.ReadFile(step.Path)
+		if err != nil {
+			return nil, err
+		}
+		release.Resources = append(release.Resources, ReleasedResource{
+			Name:     step.Resource,
+			Path:     step.Path,
+			Manifest: string(content),
+		})
+	}
+	return &release, nil
+}
+
+// Applies the manifests stored in the release again, in the order they were
+// first applied. The resources keep the readiness, checks and hooks they
+// have in the current configuration, if any.
+func (r *ResourceManager) ApplyRelease(release *Release) error {
+	tmpDir, err := ioutil.TempDir("", "kubemgr-release")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	steps := make([]PlanStep, len(release.Resources))
+	for i, res := range release.Resources {
+		filePath := path.Join(tmpDir, fmt.Sprintf("%d-%s", i, fileNameRegexp.ReplaceAllString(res.Name, "_")))
+		err = ioutil.WriteFile(filePath, []byte(res.Manifest), 0644)
+		if err != nil {
+			return err
+		}
+		steps[i] = PlanStep{
+			Resource: res.Name,
+			Path:     filePath,
+			Reasons:  []string{fmt.Sprintf("part of revision %d", release.Revision)},
+		}
+	}
+	return r.applySteps(steps)
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+func injectHash(data map[string]interface{}) (string, error) {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
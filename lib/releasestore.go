@@ -0,0 +1,366 @@
+package kubemgr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apourchet/kubemgr/lib/kubectl"
+)
+
+// ReleaseStore keeps the history of the releases of a package. Saving a
+// release gives it the next revision number.
+type ReleaseStore interface {
+	Save(release *Release) error
+	List() ([]Release, error)
+	Get(revision int) (*Release, error)
+}
+
+var (
+	ReleaseDir       string
+	ReleaseNamespace string
+	HistoryMax       int
+
+	releaseNameRegexp = regexp.MustCompile(`[^a-z0-9.-]`)
+)
+
+func init() {
+	flag.StringVar(&ReleaseDir, "release-dir", ".kubemgr/releases", "Directory in which the fake backend keeps the release history")
+	flag.StringVar(&ReleaseNamespace, "release-namespace", "", "Namespace of the secrets holding the release history, the namespace of the context when empty")
+	flag.IntVar(&HistoryMax, "history-max", 10, "Number of releases to keep in the history")
+}
+
+// Returns the store matching the backend: the fake backend keeps its history
+// in a local directory, the others in a secret of the cluster
+func NewReleaseStore(backend string, client kubectl.Client, pkg string) ReleaseStore {
+	if pkg == "" {
+		pkg = "default"
+	}
+	if backend == kubectl.BackendFake {
+		return NewDirReleaseStore(path.Join(ReleaseDir, pkg), pkg)
+	}
+	return NewSecretReleaseStore(client, ReleaseNamespace, pkg)
+}
+
+// DirReleaseStore keeps every release of a package in its own JSON file
+type DirReleaseStore struct {
+	Dir     string
+	Package string
+}
+
+func NewDirReleaseStore(dir, pkg string) *DirReleaseStore {
+	s := DirReleaseStore{}
+	s.Dir = dir
+	s.Package = pkg
+	return &s
+}
+
+func (s *DirReleaseStore) Save(release *Release) error {
+	releases, err := s.List()
+	if err != nil {
+		return err
+	}
+	release.Package = s.Package
+	release.Revision = nextRevision(releases)
+
+	err = os.MkdirAll(s.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(release, "", "   ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(s.revisionPath(release.Revision), content, 0644)
+	if err != nil {
+		return err
+	}
+
+	releases = append(releases, *release)
+	for _, old := range expiredReleases(releases) {
+		err = os.Remove(s.revisionPath(old.Revision))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DirReleaseStore) List() ([]Release, error) {
+	files, err := ioutil.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return []Release{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	releases := []Release{}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		content, err := ioutil.ReadFile(path.Join(s.Dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		release := Release{}
+		err = json.Unmarshal(content, &release)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read release '%s': %v", file.Name(), err)
+		}
+		releases = append(releases, release)
+	}
+	sortReleases(releases)
+	return releases, nil
+}
+
+func (s *DirReleaseStore) Get(revision int) (*Release, error) {
+	releases, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	return findRelease(releases, revision)
+}
+
+func (s *DirReleaseStore) revisionPath(revision int) string {
+	return path.Join(s.Dir, fmt.Sprintf("v%d.json", revision))
+}
+
+// SecretReleaseStore keeps every release of a package gzipped in its own
+// secret of the cluster, labelled with the package and the revision so that
+// the history can be listed
+type SecretReleaseStore struct {
+	Client    kubectl.Client
+	Namespace string
+	Package   string
+}
+
+const (
+	LabelReleasePackage  = "kubemgr.io/release-package"
+	LabelReleaseRevision = "kubemgr.io/release-revision"
+)
+
+func NewSecretReleaseStore(client kubectl.Client, namespace, pkg string) *SecretReleaseStore {
+	s := SecretReleaseStore{}
+	s.Client = client
+	s.Namespace = namespace
+	s.Package = pkg
+	return &s
+}
+
+func (s *SecretReleaseStore) Save(release *Release) error {
+	releases, err := s.List()
+	if err != nil {
+		return err
+	}
+	release.Package = s.Package
+	release.Revision = nextRevision(releases)
+	encoded, err := encodeRelease(*release)
+	if err != nil {
+		return err
+	}
+
+	// Another run recording the same revision meanwhile must not be overwritten
+	secret := s.secret(release.Revision)
+	secret["type"] = "Opaque"
+	secret["data"] = map[string]interface{}{"release": encoded}
+	err = s.withSecretFile(secret, func(filePath string) error {
+		exists, err := s.Client.Exists(context.Background(), filePath)
+		if err != nil {
+			return err
+		} else if exists {
+			return fmt.Errorf("Revision %d of %s was recorded by another run, try again", release.Revision, s.Package)
+		}
+		return s.Client.Apply(context.Background(), filePath)
+	})
+	if err != nil {
+		return err
+	}
+
+	releases = append(releases, *release)
+	for _, old := range expiredReleases(releases) {
+		err = s.withSecretFile(s.secret(old.Revision), func(filePath string) error {
+			return s.Client.Delete(context.Background(), filePath)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SecretReleaseStore) List() ([]Release, error) {
+	lister, ok := s.Client.(kubectl.Lister)
+	if !ok {
+		return nil, fmt.Errorf("The cluster backend cannot list objects, which the release history needs")
+	}
+	selector := fmt.Sprintf("%s=%s,%s=%s", LabelManagedBy, ManagedBy, LabelReleasePackage, s.label())
+	objects, err := lister.ListKind(context.Background(), "v1", "Secret", s.Namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := []Release{}
+	for _, obj := range objects {
+		metadata, _ := obj["metadata"].(map[string]interface{})
+		data, _ := obj["data"].(map[string]interface{})
+		value, _ := data["release"].(string)
+		release, err := decodeRelease(value)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read release '%v': %v", metadata["name"], err)
+		}
+		releases = append(releases, *release)
+	}
+	sortReleases(releases)
+	return releases, nil
+}
+
+func (s *SecretReleaseStore) Get(revision int) (*Release, error) {
+	releases, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	return findRelease(releases, revision)
+}
+
+// Returns the package as a label value, which is also part of the name of
+// its secrets
+func (s *SecretReleaseStore) label() string {
+	return releaseNameRegexp.ReplaceAllString(strings.ToLower(s.Package), "-")
+}
+
+// Returns the secret of a revision, which goes to the namespace of the
+// context when the store has none
+func (s *SecretReleaseStore) secret(revision int) map[string]interface{} {
+	metadata := map[string]interface{}{
+		"name": fmt.Sprintf("kubemgr-release-%s.v%d", s.label(), revision),
+		"labels": map[string]interface{}{
+			LabelManagedBy:       ManagedBy,
+			LabelReleasePackage:  s.label(),
+			LabelReleaseRevision: strconv.Itoa(revision),
+		},
+	}
+	if s.Namespace != "" {
+		metadata["namespace"] = s.Namespace
+	}
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   metadata,
+	}
+}
+
+// Writes the secret to a temporary manifest, since the client works on files
+func (s *SecretReleaseStore) withSecretFile(secret map[string]interface{}, fn func(filePath string) error) error {
+	file, err := ioutil.TempFile("", "kubemgr-release")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	err = json.NewEncoder(file).Encode(secret)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	return fn(file.Name())
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+func nextRevision(releases []Release) int {
+	if len(releases) == 0 {
+		return 1
+	}
+	return releases[len(releases)-1].Revision + 1
+}
+
+// Returns the oldest releases beyond the HistoryMax most recent ones
+func expiredReleases(releases []Release) []Release {
+	if HistoryMax <= 0 || len(releases) <= HistoryMax {
+		return nil
+	}
+	return releases[:len(releases)-HistoryMax]
+}
+
+func sortReleases(releases []Release) {
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Revision < releases[j].Revision
+	})
+}
+
+func findRelease(releases []Release, revision int) (*Release, error) {
+	for i := range releases {
+		if releases[i].Revision == revision {
+			return &releases[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Revision %d not found in the release history", revision)
+}
+
+func encodeRelease(release Release) (string, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	err := json.NewEncoder(writer).Encode(release)
+	if err != nil {
+		return "", err
+	}
+	err = writer.Close()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decodeRelease(value string) (*Release, error) {
+	compressed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	release := Release{}
+	err = json.NewDecoder(reader).Decode(&release)
+	return &release, err
+}
+
+func parseRevision(target string) (int, error) {
+	revision, err := strconv.Atoi(strings.TrimPrefix(target, "v"))
+	if err != nil || revision <= 0 {
+		return 0, fmt.Errorf("Invalid revision '%s'", target)
+	}
+	return revision, nil
+}
+
+func printHistory(releases []Release) {
+	if len(releases) == 0 {
+		fmt.Println("No releases")
+		return
+	}
+	fmt.Printf("%-9s %-21s %-10s %-13s %s\n", "REVISION", "TIME", "RESOURCES", "INJECTS", "DESCRIPTION")
+	for _, release := range releases {
+		fmt.Printf("%-9d %-21s %-10d %-13s %s\n", release.Revision, release.Time.Format(time.RFC3339),
+			len(release.Resources), shortHash(release.InjectHash), release.Description)
+	}
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
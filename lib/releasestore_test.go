@@ -0,0 +1,55 @@
+package kubemgr
+
+import (
+	"context"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/apourchet/kubemgr/lib/kubectl"
+)
+
+func TestSecretReleaseStoreListsItsSecretsOnly(t *testing.T) {
+	historyMax := HistoryMax
+	HistoryMax = 2
+	defer func() { HistoryMax = historyMax }()
+	cluster := kubectl.NewFakeCluster()
+	store := NewSecretReleaseStore(cluster, "", "shop")
+	for _, description := range []string{"apply a", "apply b", "recreate c"} {
+		err := store.Save(&Release{Description: description})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Objects with the labels of the history that are not its secrets
+	labels := `"labels": {"app.kubernetes.io/managed-by": "kubemgr", "kubemgr.io/release-package": "shop"}`
+	for name, manifest := range map[string]string{
+		"configmap.json": `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "kubemgr-release-shop.v9", ` + labels + `}}`,
+		"secret.json":    `{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "kubemgr-release-shop.v9", "namespace": "ops", ` + labels + `}}`,
+	} {
+		filePath := path.Join(t.TempDir(), name)
+		err := ioutil.WriteFile(filePath, []byte(manifest), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cluster.Apply(context.Background(), filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	releases, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 2 || releases[0].Revision != 2 || releases[1].Revision != 3 {
+		t.Fatalf("Got releases %v, want revisions 2 and 3", releases)
+	}
+	if releases[1].Description != "recreate c" {
+		t.Errorf("Got description %q", releases[1].Description)
+	}
+	if _, found := cluster.Objects["Secret/default/kubemgr-release-shop.v3"]; !found {
+		t.Errorf("Release was not recorded in the default namespace: %v", cluster.Objects)
+	}
+}
@@ -0,0 +1,156 @@
+package kubemgr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// RemotePackage is a configuration imported from a URL, mirrored in the cache
+// along with the templates and inject files it refers to, so that its relative
+// paths resolve the same way they do on the server
+type RemotePackage struct {
+	URL    string
+	Base   *url.URL
+	Dir    string
+	Config string
+}
+
+var (
+	CacheDir        string
+	RefreshImports  bool
+	DownloadTimeout time.Duration
+)
+
+func init() {
+	flag.StringVar(&CacheDir, "cache-dir", ".kubemgr/cache", "Directory in which the packages imported from URLs are downloaded")
+	flag.BoolVar(&RefreshImports, "refresh-imports", false, "Download the packages imported from URLs again instead of using the cache")
+	flag.DurationVar(&DownloadTimeout, "download-timeout", time.Minute, "Maximum time spent downloading each file of the packages imported from URLs")
+}
+
+func NewRemotePackage(rawURL string) (*RemotePackage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return nil, fmt.Errorf("Import %s does not name a configuration file", rawURL)
+	}
+	base, err := u.Parse(".")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(base.String()))
+	dir := path.Join(CacheDir, hex.EncodeToString(sum[:8]))
+	return &RemotePackage{
+		URL:    rawURL,
+		Base:   base,
+		Dir:    dir,
+		Config: path.Join(dir, path.Base(u.Path)),
+	}, nil
+}
+
+// Downloads the package unless the cache already has it. When a digest is
+// given, a cached copy that does not match it is downloaded again, and a
+// download that does not match it is an error.
+func (p *RemotePackage) Fetch(digest string) error {
+	refresh := RefreshImports
+	for {
+		err := p.download(refresh)
+		if err != nil {
+			return err
+		}
+		actual, err := p.Digest()
+		if err != nil {
+			return err
+		}
+		if digest == "" || strings.EqualFold(actual, digest) {
+			glog.V(2).Infof("Using %s from %s (sha256 %s)", p.URL, p.Dir, actual)
+			return nil
+		}
+		if refresh {
+			return fmt.Errorf("Checksum mismatch for %s: want sha256 %s, got %s", p.URL, digest, actual)
+		}
+		glog.Infof("Cached copy of %s does not match its sha256, downloading it again", p.URL)
+		refresh = true
+	}
+}
+
+// Digest is the sha256 of the listing that sha256sum prints for the files of
+// the package, sorted by their path relative to the package's directory
+func (p *RemotePackage) Digest() (string, error) {
+	return packageDigest(p.Config)
+}
+
+// Resolves a path found in the package's configuration against its URL
+func (p *RemotePackage) Resolve(location string) (string, error) {
+	ref, err := p.Base.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return ref.String(), nil
+}
+
+func (p *RemotePackage) download(refresh bool) error {
+	if refresh {
+		forgetConfig(p.Config)
+	}
+	configFile := path.Base(p.Config)
+	err := p.downloadFile(configFile, refresh)
+	if err != nil {
+		return err
+	}
+	config, err := LoadConfig(p.Config)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range packageFiles(p.Config, config) {
+		if ref, err := url.Parse(file); err != nil || ref.IsAbs() || path.IsAbs(file) || file == ".." || strings.HasPrefix(file, "../") {
+			return fmt.Errorf("%s refers to %s, outside of its directory", p.URL, file)
+		}
+		if file == configFile {
+			continue
+		}
+		err = p.downloadFile(file, refresh)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *RemotePackage) downloadFile(file string, refresh bool) error {
+	localPath := path.Join(p.Dir, file)
+	if !refresh {
+		exists, err := fileExists(localPath)
+		if err != nil || exists {
+			return err
+		}
+	}
+	href, err := p.Resolve(file)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(path.Dir(localPath), 0755)
+	if err != nil {
+		return err
+	}
+	glog.Infof("Downloading %s", href)
+	return wget(href, localPath)
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
@@ -0,0 +1,249 @@
+package kubemgr
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// packageServer serves a set of files and counts how many times each one was
+// downloaded
+type packageServer struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	files     map[string]string
+	downloads map[string]int
+}
+
+func newPackageServer(t *testing.T, files map[string]string) *packageServer {
+	s := &packageServer{files: files, downloads: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		content, found := s.files[req.URL.Path]
+		if !found {
+			http.NotFound(w, req)
+			return
+		}
+		s.downloads[req.URL.Path]++
+		fmt.Fprint(w, content)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *packageServer) set(file, content string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.files[file] = content
+}
+
+func (s *packageServer) count(file string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.downloads[file]
+}
+
+const syslogConfig = `{
+    "package": "syslog",
+    "injects": [{"name": "mine", "path": "injects.json"}],
+    "resources": {"syslog-svc": {"path": "k8s/syslog-svc.json"}}
+}`
+
+func syslogPackage() map[string]string {
+	return map[string]string{
+		"/syslog/kubeconfig.json":      syslogConfig,
+		"/syslog/injects.json":         `{"port": 514}`,
+		"/syslog/k8s/syslog-svc.json":  `{"kind": "Service"}`,
+		"/syslog/k8s/unreferenced.txt": `never downloaded`,
+	}
+}
+
+// Returns the digest of the syslog package as sha256sum would list it
+func syslogDigest(files map[string]string) string {
+	listing := ""
+	for _, file := range []string{"injects.json", "k8s/syslog-svc.json", "kubeconfig.json"} {
+		listing += fmt.Sprintf("%x  %s\n", sha256.Sum256([]byte(files["/syslog/"+file])), file)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(listing)))
+}
+
+func useCacheDir(t *testing.T) {
+	cacheDir, refresh := CacheDir, RefreshImports
+	CacheDir, RefreshImports = t.TempDir(), false
+	t.Cleanup(func() { CacheDir, RefreshImports = cacheDir, refresh })
+}
+
+func TestRemotePackageDownloadsReferencedFiles(t *testing.T) {
+	useCacheDir(t)
+	server := newPackageServer(t, syslogPackage())
+
+	pkg, err := NewRemotePackage(server.URL + "/syslog/kubeconfig.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pkg.Fetch("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"kubeconfig.json", "injects.json", "k8s/syslog-svc.json"} {
+		content, err := ioutil.ReadFile(path.Join(pkg.Dir, file))
+		if err != nil {
+			t.Fatalf("%s was not downloaded: %v", file, err)
+		}
+		if string(content) != server.files["/syslog/"+file] {
+			t.Errorf("%s: got %q", file, content)
+		}
+	}
+	if n := server.count("/syslog/k8s/unreferenced.txt"); n != 0 {
+		t.Errorf("Unreferenced file was downloaded %d time(s)", n)
+	}
+
+	// The cached copy is used by the next runs
+	err = pkg.Fetch("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := server.count("/syslog/kubeconfig.json"); n != 1 {
+		t.Errorf("Configuration was downloaded %d times, want 1", n)
+	}
+}
+
+func TestRemotePackageRejectsFilesOutsideOfItsDirectory(t *testing.T) {
+	useCacheDir(t)
+	for _, location := range []string{"../secrets.json", "/etc/passwd", "http://elsewhere/k8s.json"} {
+		server := newPackageServer(t, map[string]string{
+			"/syslog/kubeconfig.json": `{"resources": {"svc": {"path": "` + location + `"}}}`,
+		})
+		pkg, err := NewRemotePackage(server.URL + "/syslog/kubeconfig.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = pkg.Fetch("")
+		if err == nil || !strings.Contains(err.Error(), "outside of its directory") {
+			t.Errorf("%s: got %v, want an error", location, err)
+		}
+	}
+}
+
+func TestImportManagerResolvesRelativeImportsAgainstTheURL(t *testing.T) {
+	useCacheDir(t)
+	files := syslogPackage()
+	files["/app/kubeconfig.json"] = `{"imports": [{"path": "../syslog/kubeconfig.json"}]}`
+	server := newPackageServer(t, files)
+
+	dir := t.TempDir()
+	root := path.Join(dir, "kubeconfig.json")
+	err := ioutil.WriteFile(root, []byte(`{"imports": [{"path": "`+server.URL+`/app/kubeconfig.json"}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importManager := NewImportManager()
+	imports, err := importManager.GetImports(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports, err = importManager.GetImportClosure(imports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imports) != 2 {
+		t.Fatalf("Got imports %v, want 2", imports)
+	}
+	config, err := LoadConfig(imports[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Package != "syslog" {
+		t.Errorf("Got package %q from %s, want syslog", config.Package, imports[1])
+	}
+	if n := server.count("/syslog/k8s/syslog-svc.json"); n != 1 {
+		t.Errorf("Template of the relative import was downloaded %d time(s), want 1", n)
+	}
+}
+
+func TestRemotePackageChecksSha256(t *testing.T) {
+	useCacheDir(t)
+	files := syslogPackage()
+	server := newPackageServer(t, files)
+
+	pkg, err := NewRemotePackage(server.URL + "/syslog/kubeconfig.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pkg.Fetch(syslogDigest(files))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrong := strings.Repeat("0", 64)
+	err = pkg.Fetch(wrong)
+	if err == nil || !strings.Contains(err.Error(), "Checksum mismatch") {
+		t.Fatalf("Got %v, want a checksum mismatch", err)
+	}
+	if !strings.Contains(err.Error(), syslogDigest(files)) {
+		t.Errorf("Error does not give the actual digest: %v", err)
+	}
+}
+
+func TestRemotePackageDownloadsStaleCacheAgain(t *testing.T) {
+	useCacheDir(t)
+	files := syslogPackage()
+	server := newPackageServer(t, files)
+
+	pkg, err := NewRemotePackage(server.URL + "/syslog/kubeconfig.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pkg.Fetch(syslogDigest(files))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The package is republished and its pin updated, the cached copy no
+	// longer matches
+	server.set("/syslog/injects.json", `{"port": 1514}`)
+	err = pkg.Fetch(syslogDigest(server.files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path.Join(pkg.Dir, "injects.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != `{"port": 1514}` {
+		t.Errorf("Cached copy was not downloaded again: %s", content)
+	}
+	if n := server.count("/syslog/kubeconfig.json"); n != 2 {
+		t.Errorf("Configuration was downloaded %d times, want 2", n)
+	}
+}
+
+func TestWgetTimesOut(t *testing.T) {
+	timeout := DownloadTimeout
+	DownloadTimeout = 50 * time.Millisecond
+	defer func() { DownloadTimeout = timeout }()
+	done := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	filePath := path.Join(t.TempDir(), "kubeconfig.json")
+	err := wget(server.URL+"/kubeconfig.json", filePath)
+	if err == nil {
+		t.Fatal("Download of a server that never answers did not time out")
+	}
+	if exists, _ := fileExists(filePath); exists {
+		t.Errorf("Failed download left %s behind", filePath)
+	}
+}
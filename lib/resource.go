@@ -1,101 +1,125 @@
 package kubemgr
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/apourchet/kubemgr/lib/kubectl"
 	"github.com/golang/glog"
 )
 
-type PackagedResources struct {
-	Package   string
-	Resources map[string]Resource
-}
-
 type Resource struct {
-	Path string
-	Deps []string
+	Path      string
+	Deps      []string
+	Readiness kubectl.Readiness
+	Checks    []Check
+
+	PreApply   []Hook
+	PostApply  []Hook
+	PreDelete  []Hook
+	PostDelete []Hook
+
+	// Resources whose rendered manifests roll the pods of this one out when
+	// they change
+	RollOn []string
+
+	// Package that declares the resource, the resource's key in it, and where
+	// it is declared
+	Package string `json:"-"`
+	Key     string `json:"-"`
+	File    string `json:"-"`
+	Line    int    `json:"-"`
 }
 
 type ResourceManagerInterface interface {
 	FetchResources(filepath string) error
 	GetImportedResources(filePaths []string) error
 	SetInjector(injector InjectorInterface) error
+	SetClient(client kubectl.Client) error
 	ApplyResources(pattern string) error
 	CheckResources(pattern string) error
 	DeleteResources(pattern string) error
 	PrepResources(pattern string) error
+	PlanResources(pattern string) error
+	DiffResources(pattern string) error
+	Plan(pattern string) ([]PlanStep, error)
+	Release(pattern string) (*Release, error)
+	ApplyRelease(release *Release) error
 	AssertValid() error
+	Validate() []Problem
 	String() string
 }
 
 type ResourceManager struct {
 	Injector  InjectorInterface
+	Client    kubectl.Client
 	Resources map[string]Resource
 	Prepared  map[string]bool
 	Applied   map[string]bool
 	Deleted   map[string]bool
+	Metadata  map[string]MetadataConfig
+
+	// Aliases maps the short name of every imported resource that does not
+	// clash with another one to its namespaced name
+	Aliases map[string]string
+
+	snapshots    []*kubectl.Snapshot
+	rendered     map[string]string
+	revision     string
+	revisionOnce sync.Once
+	mutex        sync.Mutex
 }
 
 var (
 	SkipDeps bool
+	Cascade  bool
+	Force    bool
+	Atomic   bool
 )
 
 func init() {
 	flag.BoolVar(&SkipDeps, "skip-deps", false, "Skip the dependencies")
+	flag.BoolVar(&Cascade, "cascade", false, "Also delete the resources that depend on the target")
+	flag.BoolVar(&Force, "force", false, "Delete resources even if they still have live dependents")
+	flag.BoolVar(&Atomic, "atomic", false, "Roll the cluster back to its previous state if the apply fails")
 }
 
 func NewResourceManager() ResourceManagerInterface {
 	r := ResourceManager{}
 	r.Injector = nil
+	r.Client = nil
 	r.Resources = make(map[string]Resource)
 	r.Prepared = make(map[string]bool)
 	r.Applied = make(map[string]bool)
 	r.Deleted = make(map[string]bool)
+	r.Metadata = make(map[string]MetadataConfig)
+	r.Aliases = make(map[string]string)
+	r.rendered = make(map[string]string)
 	return &r
 }
 
 func (r *ResourceManager) FetchResources(filepath string) error {
-	configBytes, err := ioutil.ReadFile(filepath)
-	if err != nil {
-		return err
-	}
-	pkg := PackagedResources{}
-	err = json.Unmarshal(configBytes, &pkg)
+	config, err := LoadConfig(filepath)
 	if err != nil {
 		return err
 	}
-	for name, res := range pkg.Resources {
-		r.Resources[name] = res
-	}
+	r.addResources(config)
 	return nil
 }
 
 func (r *ResourceManager) GetImportedResources(filePaths []string) error {
 	for _, fpath := range filePaths {
-		configBytes, err := ioutil.ReadFile(fpath)
+		config, err := LoadConfig(fpath)
 		if err != nil {
 			return err
 		}
-		pkg := PackagedResources{}
-		err = json.Unmarshal(configBytes, &pkg)
-		if err != nil {
-			return err
-		}
-		prefix := path.Dir(fpath)
-		for name, res := range pkg.Resources {
-			namespacedName := pkg.Package + "." + name
-			prefixedResource := prefixResource(pkg.Package, prefix, res)
-			r.Resources[namespacedName] = prefixedResource
-			if _, found := r.Resources[name]; !found {
-				r.Resources[name] = prefixedResource
-			}
-		}
+		r.addImportedResources(fpath, config)
 	}
 	return nil
 }
@@ -105,37 +129,46 @@ func (r *ResourceManager) SetInjector(injector InjectorInterface) error {
 	return nil
 }
 
+func (r *ResourceManager) SetClient(client kubectl.Client) error {
+	r.Client = client
+	return nil
+}
+
 func (r *ResourceManager) ApplyResources(pattern string) error {
 	err := r.PrepResources(pattern)
 	if err != nil {
 		return err
 	}
 
-	resources := r.findAllDependencies(pattern)
-	for _, resourceName := range resources {
-		if _, found := r.Applied[resourceName]; !found {
-			resource := r.Resources[resourceName]
-			for _, depName := range resource.Deps {
-				if SkipDeps {
-					continue
-				}
-				err = r.ApplyResources(depName)
-				if err != nil {
-					return err
-				}
-			}
-			path := r.Injector.GetInjectedFilePath(resource.Path)
-			err = kubectl.Apply(path)
-			if err != nil {
-				return err
-			}
-			err = kubectl.Check(path)
+	steps, err := r.Plan(pattern)
+	if err != nil {
+		return err
+	}
+	err = r.applySteps(steps)
+	if err != nil || !Prune {
+		return err
+	}
+	return r.pruneResources()
+}
+
+// Applies the steps in order, or wave by wave when running in parallel,
+// rolling back what was applied on failure in atomic mode
+func (r *ResourceManager) applySteps(steps []PlanStep) error {
+	var err error
+	if Parallel > 1 {
+		err = r.applyInWaves(steps)
+	} else {
+		for _, step := range steps {
+			err = r.applyStep(context.Background(), step)
 			if err != nil {
-				return err
+				break
 			}
-			r.Applied[resourceName] = true
 		}
 	}
+
+	if err != nil && Atomic {
+		return r.rollback(err)
+	}
 	return err
 }
 
@@ -144,7 +177,11 @@ func (r *ResourceManager) CheckResources(pattern string) error {
 	for _, resourceName := range resources {
 		resource := r.Resources[resourceName]
 		path := r.Injector.GetInjectedFilePath(resource.Path)
-		err := kubectl.Check(path)
+		err := r.Client.Wait(context.Background(), path, resource.Readiness)
+		if err != nil {
+			return err
+		}
+		err = r.runChecks(context.Background(), resourceName)
 		if err != nil {
 			return err
 		}
@@ -152,17 +189,54 @@ func (r *ResourceManager) CheckResources(pattern string) error {
 	return nil
 }
 
+// Deletes the matching resources in reverse dependency order, so that
+// dependents are always torn down before the resources they depend on.
 func (r *ResourceManager) DeleteResources(pattern string) error {
+	graph := r.dependencyGraph()
+	dependents := graph.Reverse()
 	resources := r.findMatchingResources(pattern)
-	for _, resourceName := range resources {
+	if Cascade {
+		resources = dependents.Closure(resources)
+	}
+
+	order, err := graph.TopologicalOrder(resources)
+	if err != nil {
+		return err
+	}
+
+	for _, resourceName := range order {
+		err = r.prepResource(resourceName)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !Force {
+		err = r.assertNoLiveDependents(order, dependents)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := len(order) - 1; i >= 0; i-- {
+		resourceName := order[i]
 		if _, found := r.Deleted[resourceName]; !found {
 			resource := r.Resources[resourceName]
 			path := r.Injector.GetInjectedFilePath(resource.Path)
-			err := kubectl.Delete(path)
+			ctx := kubectl.WithLogPrefix(context.Background(), "["+resourceName+"] ")
+			err := r.runHooks(ctx, resourceName, HookPreDelete)
+			if err != nil {
+				return err
+			}
+			err = r.Client.Delete(ctx, path)
 			if err != nil {
 				glog.Warningf("Error: %v", err)
 			}
 			r.Deleted[resourceName] = true
+			err = r.runHooks(ctx, resourceName, HookPostDelete)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -171,29 +245,81 @@ func (r *ResourceManager) DeleteResources(pattern string) error {
 func (r *ResourceManager) PrepResources(pattern string) error {
 	resources := r.findAllDependencies(pattern)
 	for _, resourceName := range resources {
-		if _, found := r.Prepared[resourceName]; !found {
-			resource := r.Resources[resourceName]
-			err := r.Injector.Inject(resource.Path)
-			if err != nil {
-				return err
-			}
-			r.Prepared[resourceName] = true
+		err := r.prepResource(resourceName)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
 func (r *ResourceManager) AssertValid() error {
-	for resourceName, res := range r.Resources {
+	problems := r.Validate()
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// Returns every problem with the resources, located where each resource is
+// declared. A problem shared by several resources is only reported once.
+func (r *ResourceManager) Validate() []Problem {
+	problems := []Problem{}
+	seen := make(map[Problem]bool)
+	report := func(res Resource, format string, args ...interface{}) {
+		problem := Problem{File: res.File, Line: res.Line, Path: "resources." + res.Key, Message: fmt.Sprintf(format, args...)}
+		if !seen[problem] {
+			seen[problem] = true
+			problems = append(problems, problem)
+		}
+	}
+
+	graph := r.dependencyGraph()
+	for _, resourceName := range graph.Nodes() {
+		res := r.Resources[resourceName]
+		if exists, _ := fileExists(res.Path); !exists {
+			report(res, "Template not found: %s", res.Path)
+		}
 		for _, dep := range res.Deps {
 			if len(r.findMatchingResources(dep)) <= 0 {
-				return fmt.Errorf("Dependency not found: %s => %s", resourceName, dep)
+				report(res, "Dependency not found: %s", dep)
+			}
+		}
+		for _, target := range res.RollOn {
+			if len(r.findMatchingResources(target)) <= 0 {
+				report(res, "Resource to roll on not found: %s", target)
+			}
+		}
+		if err := res.Readiness.Validate(); err != nil {
+			report(res, "Invalid readiness: %v", err)
+		}
+		for _, check := range res.Checks {
+			if err := check.Validate(); err != nil {
+				report(res, "Invalid check: %v", err)
+			}
+		}
+		for _, phase := range []string{HookPreApply, HookPostApply, HookPreDelete, HookPostDelete} {
+			for _, hook := range res.Hooks(phase) {
+				if err := hook.Validate(); err != nil {
+					report(res, "Invalid %s hook: %v", phase, err)
+				}
 			}
 		}
 	}
 
-	// TODO No cycles allowed
-	return nil
+	for pkg, config := range r.Metadata {
+		if err := config.Validate(); err != nil {
+			problems = append(problems, Problem{Message: fmt.Sprintf("Invalid metadata for package '%s': %v", pkg, err)})
+		}
+	}
+
+	for _, cycle := range r.rollOnGraph().Cycles() {
+		report(r.Resources[cycle[0]], "rollOn cycle detected: %s", strings.Join(cycle, " -> "))
+	}
+	for _, cycle := range graph.Cycles() {
+		report(r.Resources[cycle[0]], "Dependency cycle detected: %s", strings.Join(cycle, " -> "))
+	}
+	return problems
 }
 
 func (r *ResourceManager) String() string {
@@ -201,50 +327,129 @@ func (r *ResourceManager) String() string {
 	return string(content)
 }
 
+// Returns the names of the resources that the pattern matches, either by
+// their own name or by their alias
 func (r *ResourceManager) findMatchingResources(pattern string) []string {
-	ret := []string{}
+	matches := make(map[string]interface{})
 	for resourceName, _ := range r.Resources {
 		if match, err := resourceNameMatches(pattern, resourceName); err == nil && match {
-			ret = append(ret, resourceName)
+			matches[resourceName] = true
 		}
 	}
-	return ret
+	for alias, resourceName := range r.Aliases {
+		if match, err := resourceNameMatches(pattern, alias); err == nil && match {
+			matches[resourceName] = true
+		}
+	}
+	return mapKeys(matches)
 }
 
 func (r *ResourceManager) findAllDependencies(pattern string) []string {
-	allResources := make(map[string]interface{})
 	resources := r.findMatchingResources(pattern)
-	for _, res := range resources {
-		allResources[res] = true
+	if SkipDeps {
+		return resources
 	}
+	return r.dependencyGraph().Closure(resources)
+}
 
-	if SkipDeps {
-		return mapKeys(allResources)
+func (r *ResourceManager) prepResource(resourceName string) error {
+	if _, found := r.Prepared[resourceName]; found {
+		return nil
+	}
+	for _, target := range r.rollOnGraph()[resourceName] {
+		err := r.prepResource(target)
+		if err != nil {
+			return err
+		}
 	}
 
-	for i := 0; i < len(resources); i++ {
-		resource := r.Resources[resources[i]]
-		for _, dep := range resource.Deps {
-			if _, found := allResources[dep]; !found {
-				resources = append(resources, dep)
-				allResources[dep] = true
+	resource := r.Resources[resourceName]
+	err := r.Injector.Inject(resource.Path)
+	if err != nil {
+		return err
+	}
+	err = r.stampMetadata(resource)
+	if err != nil {
+		return err
+	}
+	err = r.stampRollChecksum(resourceName)
+	if err != nil {
+		return err
+	}
+	r.Prepared[resourceName] = true
+	return nil
+}
+
+// Registers the resources of the top-level configuration
+func (r *ResourceManager) addResources(config *Config) {
+	r.Metadata[config.Package] = config.Metadata
+	for name, res := range config.Resources {
+		r.Resources[name] = res
+	}
+}
+
+// Registers the resources of an imported configuration under their namespaced
+// name, and under their own name as an alias when no other resource has it
+func (r *ResourceManager) addImportedResources(filePath string, config *Config) {
+	prefix := path.Dir(filePath)
+	if _, found := r.Metadata[config.Package]; !found {
+		r.Metadata[config.Package] = config.Metadata
+	}
+	for name, res := range config.Resources {
+		namespacedName := config.Package + "." + name
+		r.Resources[namespacedName] = prefixResource(config.Package, prefix, res)
+		if _, found := r.Resources[name]; !found {
+			if _, found := r.Aliases[name]; !found {
+				r.Aliases[name] = namespacedName
 			}
 		}
 	}
+}
 
-	return mapKeys(allResources)
+// Makes sure that none of the resources about to be deleted has a dependent
+// that is still present in the cluster and is not part of the deletion.
+func (r *ResourceManager) assertNoLiveDependents(resources []string, dependents DependencyGraph) error {
+	deleting := make(map[string]bool)
+	for _, resourceName := range resources {
+		deleting[resourceName] = true
+	}
+
+	for _, resourceName := range resources {
+		for _, dependent := range dependents[resourceName] {
+			if deleting[dependent] {
+				continue
+			}
+			err := r.prepResource(dependent)
+			if err != nil {
+				return err
+			}
+			path := r.Injector.GetInjectedFilePath(r.Resources[dependent].Path)
+			live, err := r.Client.Exists(context.Background(), path)
+			if err != nil {
+				return err
+			}
+			if live {
+				return fmt.Errorf("Refusing to delete %s: dependent %s still exists (use --cascade or --force)", resourceName, dependent)
+			}
+		}
+	}
+	return nil
 }
 
 // ********************
 // * HELPER FUNCTIONS *
 // ********************
 func prefixResource(namespace, prefix string, resource Resource) Resource {
-	ret := Resource{}
+	ret := resource
 	ret.Path = path.Join(prefix, resource.Path)
 	ret.Deps = make([]string, len(resource.Deps))
 	for i := range resource.Deps {
 		ret.Deps[i] = namespace + "." + resource.Deps[i]
 	}
+	ret.RollOn = make([]string, len(resource.RollOn))
+	for i := range resource.RollOn {
+		ret.RollOn[i] = namespace + "." + resource.RollOn[i]
+	}
 	return ret
 }
 
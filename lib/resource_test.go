@@ -0,0 +1,309 @@
+package kubemgr
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apourchet/kubemgr/lib/kubectl"
+)
+
+// recordingCluster is a fake cluster that records the resources applied and
+// deleted, in order, and fails to apply the resource named in failOn. When
+// eventLog is set, they are also appended to that file, which lets them be
+// ordered against commands that write to it.
+type recordingCluster struct {
+	*kubectl.FakeCluster
+	mutex    sync.Mutex
+	applied  []string
+	deleted  []string
+	failOn   string
+	eventLog string
+}
+
+func (c *recordingCluster) Apply(ctx context.Context, filePath string) error {
+	name, isResource := resourceOfFile(filePath)
+	if isResource && name == c.failOn {
+		return fmt.Errorf("Failed to apply %s", name)
+	}
+	err := c.FakeCluster.Apply(ctx, filePath)
+	if err == nil && isResource {
+		c.mutex.Lock()
+		c.applied = append(c.applied, name)
+		c.mutex.Unlock()
+		err = c.logEvent("apply " + name)
+	}
+	return err
+}
+
+func (c *recordingCluster) Delete(ctx context.Context, filePath string) error {
+	err := c.FakeCluster.Delete(ctx, filePath)
+	if name, isResource := resourceOfFile(filePath); err == nil && isResource {
+		c.mutex.Lock()
+		c.deleted = append(c.deleted, name)
+		c.mutex.Unlock()
+		err = c.logEvent("delete " + name)
+	}
+	return err
+}
+
+func (c *recordingCluster) logEvent(event string) error {
+	if c.eventLog == "" {
+		return nil
+	}
+	file, err := os.OpenFile(c.eventLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = fmt.Fprintln(file, event)
+	return err
+}
+
+// Returns the resource of an injected manifest, as opposed to the temporary
+// files that snapshots are restored from
+func resourceOfFile(filePath string) (string, bool) {
+	if !strings.HasSuffix(filePath, ".json.inj") {
+		return "", false
+	}
+	return strings.TrimSuffix(path.Base(filePath), ".json.inj"), true
+}
+
+// The shop package: app-dp depends on db-dp, which depends on db-svc, and
+// cache is on its own
+const shopConfig = `{
+    "package": "shop",
+    "resources": {
+        "db-svc": {"path": "k8s/db-svc.json"},
+        "db-dp": {"path": "k8s/db-dp.json", "deps": ["db-svc"]},
+        "app-dp": {"path": "k8s/app-dp.json", "deps": ["db-*"]},
+        "cache": {"path": "k8s/cache.json"}
+    }
+}`
+
+func configMap(name, version string) string {
+	return fmt.Sprintf(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": %q}, "data": {"version": %q}}`, name, version)
+}
+
+// Writes the shop package to a temporary directory, moves to it and loads it
+// against a fresh recording cluster
+func newShop(t *testing.T) *recordingCluster {
+	dir := t.TempDir()
+	err := os.MkdirAll(path.Join(dir, "k8s"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{"kubeconfig.json": shopConfig}
+	for _, name := range []string{"db-svc", "db-dp", "app-dp", "cache"} {
+		files["k8s/"+name+".json"] = configMap(name, "2")
+	}
+	for file, content := range files {
+		err = ioutil.WriteFile(path.Join(dir, file), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	parallel, atomic, prune, cascade, force := Parallel, Atomic, Prune, Cascade, Force
+	t.Cleanup(func() { Parallel, Atomic, Prune, Cascade, Force = parallel, atomic, prune, cascade, force })
+	return &recordingCluster{FakeCluster: kubectl.NewFakeCluster()}
+}
+
+// Loads the shop package in a new resource manager, as every run of kubemgr
+// does
+func loadShop(t *testing.T, cluster *recordingCluster) ResourceManagerInterface {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := path.Join(wd, "kubeconfig.json")
+	resourceManager, err := NewKubeMgr(configPath).loadResources(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = resourceManager.AssertValid()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resourceManager.SetClient(cluster)
+	return resourceManager
+}
+
+func assertOrder(t *testing.T, what string, got []string, want ...string) {
+	t.Helper()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Got %s %v, want %v", what, got, want)
+	}
+}
+
+func liveVersion(cluster *recordingCluster, name string) string {
+	obj, found := cluster.Objects["ConfigMap/default/"+name]
+	if !found {
+		return ""
+	}
+	data, _ := obj["data"].(map[string]interface{})
+	version, _ := data["version"].(string)
+	return version
+}
+
+func TestApplyFollowsDependencies(t *testing.T) {
+	cluster := newShop(t)
+	err := loadShop(t, cluster).ApplyResources("app-dp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOrder(t, "apply order", cluster.applied, "db-svc", "db-dp", "app-dp")
+}
+
+func TestApplyInWaves(t *testing.T) {
+	cluster := newShop(t)
+	Parallel = 4
+	err := loadShop(t, cluster).ApplyResources("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	position := make(map[string]int)
+	for i, name := range cluster.applied {
+		if _, found := position[name]; found {
+			t.Errorf("%s was applied twice", name)
+		}
+		position[name] = i
+	}
+	if len(position) != 4 {
+		t.Fatalf("Got %v applied, want the 4 resources", cluster.applied)
+	}
+	if position["db-svc"] > position["db-dp"] || position["db-dp"] > position["app-dp"] {
+		t.Errorf("Got apply order %v, a resource was applied before its dependencies", cluster.applied)
+	}
+}
+
+func TestDeleteDependentsFirst(t *testing.T) {
+	cluster := newShop(t)
+	err := loadShop(t, cluster).ApplyResources("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Cascade = true
+	err = loadShop(t, cluster).DeleteResources("db-svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOrder(t, "delete order", cluster.deleted, "app-dp", "db-dp", "db-svc")
+	if liveVersion(cluster, "cache") == "" {
+		t.Error("cache was deleted, it does not depend on db-svc")
+	}
+}
+
+func TestDeleteRefusesLiveDependents(t *testing.T) {
+	cluster := newShop(t)
+	err := loadShop(t, cluster).ApplyResources("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = loadShop(t, cluster).DeleteResources("db-svc")
+	if err == nil || !strings.Contains(err.Error(), "Refusing to delete db-svc") {
+		t.Fatalf("Got %v, want a refusal", err)
+	}
+	assertOrder(t, "deleted", cluster.deleted)
+
+	// Deleting the dependents along with it is fine
+	err = loadShop(t, cluster).DeleteResources("*-dp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loadShop(t, cluster).DeleteResources("db-svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOrder(t, "delete order", cluster.deleted, "app-dp", "db-dp", "db-svc")
+}
+
+func TestAtomicApplyRollsBack(t *testing.T) {
+	cluster := newShop(t)
+	err := ioutil.WriteFile("db-svc-v1.json", []byte(configMap("db-svc", "1")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cluster.FakeCluster.Apply(context.Background(), "db-svc-v1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Atomic = true
+	cluster.failOn = "app-dp"
+	err = loadShop(t, cluster).ApplyResources("app-dp")
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("Got %v, want the apply to be rolled back", err)
+	}
+	assertOrder(t, "apply order", cluster.applied, "db-svc", "db-dp")
+	if v := liveVersion(cluster, "db-svc"); v != "1" {
+		t.Errorf("Got db-svc at version %q after the rollback, want 1", v)
+	}
+	if v := liveVersion(cluster, "db-dp"); v != "" {
+		t.Errorf("db-dp was created by the failed apply and is still there at version %s", v)
+	}
+}
+
+func TestPruneDeletesUndeclaredObjects(t *testing.T) {
+	cluster := newShop(t)
+	err := loadShop(t, cluster).ApplyResources("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An object of the package that is no longer declared, and one that a
+	// controller created for a declared object
+	labels := `"labels": {"kubemgr.io/package": "shop"}`
+	for file, manifest := range map[string]string{
+		"old-config.json": `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "old-config", ` + labels + `}}`,
+		"endpoints.json": `{"apiVersion": "v1", "kind": "Endpoints", "metadata": {"name": "db-svc", ` + labels + `,
+			"ownerReferences": [{"kind": "Service", "name": "db-svc"}]}}`,
+	} {
+		err = ioutil.WriteFile(file, []byte(manifest), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cluster.FakeCluster.Apply(context.Background(), file)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	Prune = true
+	err = loadShop(t, cluster).ApplyResources("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for key := range cluster.Objects {
+		keys = append(keys, key)
+	}
+	all := strings.Join(keys, ",")
+	if strings.Contains(all, "old-config") {
+		t.Errorf("old-config was not pruned: %v", keys)
+	}
+	if !strings.Contains(all, "Endpoints") {
+		t.Errorf("Owned Endpoints were pruned: %v", keys)
+	}
+	if len(keys) != 5 {
+		t.Errorf("Got objects %v, want the 4 resources and the Endpoints", keys)
+	}
+}
@@ -0,0 +1,69 @@
+package kubemgr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/apourchet/kubemgr/lib/kubectl"
+	"github.com/golang/glog"
+)
+
+const (
+	AnnotationRollChecksum = "kubemgr.io/roll-checksum"
+)
+
+// Returns the graph of the resources each resource rolls on
+func (r *ResourceManager) rollOnGraph() DependencyGraph {
+	return r.graphOf(func(res Resource) []string { return res.RollOn })
+}
+
+// Injects the checksum of the rendered manifests of the resources that the
+// resource rolls on into the pod template of its objects, so that a change to
+// any of them rolls the pods out. Those resources must be prepared already.
+func (r *ResourceManager) stampRollChecksum(resourceName string) error {
+	targets := r.rollOnGraph()[resourceName]
+	if len(targets) == 0 {
+		return nil
+	}
+
+	// Aliases of the same imported resource only count once
+	checksums := make(map[string]string)
+	for _, target := range targets {
+		targetPath := r.Resources[target].Path
+		checksums[targetPath] = r.rendered[targetPath]
+	}
+	paths := make([]string, 0, len(checksums))
+	for targetPath := range checksums {
+		paths = append(paths, targetPath)
+	}
+	sort.Strings(paths)
+	hash := sha256.New()
+	for _, targetPath := range paths {
+		fmt.Fprintf(hash, "%s %s\n", targetPath, checksums[targetPath])
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	filePath := r.Injector.GetInjectedFilePath(r.Resources[resourceName].Path)
+	objects, err := kubectl.ReadObjects(filePath)
+	if err != nil {
+		return err
+	}
+	stamped := 0
+	for _, obj := range objects {
+		spec, _ := obj["spec"].(map[string]interface{})
+		template, ok := spec["template"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		childMap(childMap(template, "metadata"), "annotations")[AnnotationRollChecksum] = checksum
+		stamped++
+	}
+	if stamped == 0 {
+		glog.Warningf("%s rolls on %v but has no pod template to annotate", resourceName, targets)
+		return nil
+	}
+	glog.V(2).Infof("Stamped roll checksum %s on %s", checksum, resourceName)
+	return kubectl.WriteObjects(filePath, objects)
+}
@@ -0,0 +1,87 @@
+package kubemgr
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const appDeployment = `{
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {"name": "app-dp"},
+    "spec": {
+        "selector": {"matchLabels": {"app": "shop"}},
+        "template": {
+            "metadata": {"labels": {"app": "shop"}},
+            "spec": {"containers": [{"name": "app", "image": "shop:1"}]}
+        }
+    }
+}`
+
+// Returns the roll checksum of the pod template of the live app-dp
+func liveRollChecksum(t *testing.T, cluster *recordingCluster) string {
+	obj, found := cluster.Objects["Deployment/default/app-dp"]
+	if !found {
+		t.Fatal("app-dp is not in the cluster")
+	}
+	spec, _ := obj["spec"].(map[string]interface{})
+	template, _ := spec["template"].(map[string]interface{})
+	metadata, _ := template["metadata"].(map[string]interface{})
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	checksum, _ := annotations[AnnotationRollChecksum].(string)
+	return checksum
+}
+
+func TestRollOnChangesThePodTemplate(t *testing.T) {
+	cluster := newShop(t)
+	config := strings.Replace(shopConfig, `"deps": ["db-*"]}`, `"deps": ["db-*"], "rollOn": ["db-svc"]}`, 1)
+	err := ioutil.WriteFile("kubeconfig.json", []byte(config), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile("k8s/app-dp.json", []byte(appDeployment), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loadShop(t, cluster).ApplyResources("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum := liveRollChecksum(t, cluster)
+	if checksum == "" {
+		t.Fatalf("app-dp has no %s annotation", AnnotationRollChecksum)
+	}
+
+	// Changes to resources it does not roll on leave the pods alone, even
+	// those of its dependencies
+	for _, name := range []string{"db-dp", "cache"} {
+		err = ioutil.WriteFile("k8s/"+name+".json", []byte(configMap(name, "3")), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = loadShop(t, cluster).ApplyResources("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if liveVersion(cluster, "cache") != "3" {
+		t.Fatal("cache was not updated")
+	}
+	if got := liveRollChecksum(t, cluster); got != checksum {
+		t.Errorf("Got roll checksum %s after an unrelated change, want %s", got, checksum)
+	}
+
+	// A change to the config map rolls them out
+	err = ioutil.WriteFile("k8s/db-svc.json", []byte(configMap("db-svc", "3")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loadShop(t, cluster).ApplyResources("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := liveRollChecksum(t, cluster); got == checksum || got == "" {
+		t.Errorf("Got roll checksum %q after db-svc changed, want a new one", got)
+	}
+}
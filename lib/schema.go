@@ -0,0 +1,204 @@
+package kubemgr
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// ConfigSchema is the JSON Schema that configuration files are validated
+// against. Only the subset of the vocabulary that it uses is supported: type,
+// properties, additionalProperties, required, items, enum, minimum and local
+// $ref. Property names are matched without regard to case, like the decoder
+// does.
+//
+//go:embed kubeconfig.schema.json
+var ConfigSchema []byte
+
+type schema struct {
+	Ref                  string `json:"$ref"`
+	Type                 string
+	Properties           map[string]*schema
+	AdditionalProperties json.RawMessage
+	Required             []string
+	Items                *schema
+	Enum                 []interface{}
+	Minimum              *float64
+	Definitions          map[string]*schema
+}
+
+var (
+	rootSchema = mustParseSchema(ConfigSchema)
+)
+
+// Returns every place where the tree does not follow the configuration schema
+func validateSchema(tree *configNode) []Problem {
+	problems := []Problem{}
+	rootSchema.validate(tree, "", &problems)
+	return problems
+}
+
+func (s *schema) validate(node *configNode, nodePath string, problems *[]Problem) {
+	s = s.resolve()
+	report := func(line int, format string, args ...interface{}) {
+		*problems = append(*problems, Problem{Line: line, Path: nodePath, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if node.Kind == kindNull {
+		return
+	}
+	if s.Type != "" && !matchesType(node, s.Type) {
+		report(node.Line, "expected %s, got %s", s.Type, node.Kind)
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(node.Value, s.Enum) {
+		report(node.Line, "%v is not one of %v", node.Value, s.Enum)
+	}
+	if value, ok := node.Value.(float64); ok && s.Minimum != nil && value < *s.Minimum {
+		report(node.Line, "%v is less than %v", value, *s.Minimum)
+	}
+
+	switch node.Kind {
+	case kindObject:
+		for _, required := range s.Required {
+			if node.field(required) == nil {
+				report(node.Line, "missing required field %q", required)
+			}
+		}
+		additional, allowed := s.additional()
+		for i, key := range node.Keys {
+			childPath := joinPath(nodePath, key)
+			if property := s.property(key); property != nil {
+				property.validate(node.Values[i], childPath, problems)
+			} else if additional != nil {
+				additional.validate(node.Values[i], childPath, problems)
+			} else if !allowed {
+				message := fmt.Sprintf("unknown field %q", key)
+				if suggestion := s.suggest(key); suggestion != "" {
+					message += fmt.Sprintf(", did you mean %q?", suggestion)
+				}
+				*problems = append(*problems, Problem{Line: node.KeyLines[i], Path: nodePath, Message: message})
+			}
+		}
+	case kindArray:
+		if s.Items != nil {
+			for i, item := range node.Values {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", nodePath, i), problems)
+			}
+		}
+	}
+}
+
+func (s *schema) resolve() *schema {
+	for s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/definitions/")
+		s = rootSchema.Definitions[name]
+	}
+	return s
+}
+
+func (s *schema) property(key string) *schema {
+	for name, property := range s.Properties {
+		if strings.EqualFold(name, key) {
+			return property
+		}
+	}
+	return nil
+}
+
+// Returns the schema of the properties that are not listed, or whether they
+// are allowed at all when it is a boolean
+func (s *schema) additional() (*schema, bool) {
+	if len(s.AdditionalProperties) == 0 {
+		return nil, true
+	}
+	allowed := true
+	if json.Unmarshal(s.AdditionalProperties, &allowed) == nil {
+		return nil, allowed
+	}
+	additional := schema{}
+	json.Unmarshal(s.AdditionalProperties, &additional)
+	return &additional, true
+}
+
+// Returns the property closest to the unknown key, if any is close enough to
+// be a typo
+func (s *schema) suggest(key string) string {
+	best, bestDistance := "", 3
+	for name := range s.Properties {
+		distance := editDistance(strings.ToLower(key), strings.ToLower(name))
+		if distance < bestDistance || (distance == bestDistance && name < best) {
+			best, bestDistance = name, distance
+		}
+	}
+	return best
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+func mustParseSchema(content []byte) *schema {
+	s := schema{}
+	err := json.Unmarshal(content, &s)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid configuration schema: %v", err))
+	}
+	return &s
+}
+
+func matchesType(node *configNode, schemaType string) bool {
+	switch schemaType {
+	case "integer":
+		value, ok := node.Value.(float64)
+		return node.Kind == kindNumber && ok && value == math.Trunc(value)
+	case "number":
+		return node.Kind == kindNumber
+	}
+	return node.Kind == schemaType
+}
+
+func inEnum(value interface{}, enum []interface{}) bool {
+	for _, allowed := range enum {
+		if reflect.DeepEqual(value, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -1,10 +1,17 @@
 package kubemgr
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"path"
+	"strings"
 
+	"github.com/ghodss/yaml"
 	"github.com/golang/glog"
 )
 
@@ -18,21 +25,63 @@ func fileExists(filePath string) (bool, error) {
 	}
 }
 
+// Decodes a configuration or inject file written in JSON or in YAML, which is
+// told by the extension of the file or else by its content
+func unmarshalFile(filePath string, content []byte, v interface{}) error {
+	if isYAML(filePath, content) {
+		return yaml.Unmarshal(content, v)
+	}
+	return json.Unmarshal(content, v)
+}
+
+// Decodes a file like unmarshalFile, failing on any field that the value does
+// not have
+func unmarshalStrict(filePath string, content []byte, v interface{}) error {
+	if isYAML(filePath, content) {
+		return yaml.UnmarshalStrict(content, v, yaml.DisallowUnknownFields)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+func isYAML(filePath string, content []byte) bool {
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".yaml", ".yml":
+		return true
+	case ".json":
+		return false
+	}
+	trimmed := bytes.TrimSpace(content)
+	return len(trimmed) > 0 && trimmed[0] != '{'
+}
+
+// Downloads the file through a temporary file, so that a failed download
+// never leaves a partial file behind
 func wget(href string, filePath string) error {
-	out, err := os.Create(filePath)
+	client := http.Client{Timeout: DownloadTimeout}
+	resp, err := client.Get(href)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to download %s: %s", href, resp.Status)
+	}
 
-	resp, err := http.Get(href)
+	out, err := ioutil.TempFile(path.Dir(filePath), ".download-")
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-
 	_, err = io.Copy(out, resp.Body)
-	return err
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Rename(out.Name(), filePath)
 }
 
 func mapKeys(m map[string]interface{}) []string {
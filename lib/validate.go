@@ -0,0 +1,72 @@
+package kubemgr
+
+import (
+	"fmt"
+	"path"
+)
+
+// Validates the configuration and all of its imports, printing every problem
+// found along with its file and line instead of stopping at the first one
+func (k *KubeMgr) Validate() error {
+	filePath := path.Base(k.filePath)
+	problems := []Problem{}
+	importManager := NewImportManager()
+	resourceManager := NewResourceManager().(*ResourceManager)
+
+	// Every file is checked against the schema, even past the broken ones, and
+	// what could be decoded of their resources is checked below
+	files := []string{filePath}
+	seen := make(map[string]bool)
+	for i := 0; i < len(files); i++ {
+		if seen[files[i]] {
+			continue
+		}
+		seen[files[i]] = true
+		config, err := LoadConfig(files[i])
+		if err != nil {
+			problems = append(problems, asProblems(files[i], err)...)
+			config = lenientConfig(files[i])
+		}
+		if i == 0 {
+			resourceManager.addResources(config)
+		} else {
+			resourceManager.addImportedResources(files[i], config)
+		}
+		for _, imp := range config.Imports {
+			importPath, err := importManager.ResolveImport(files[i], imp)
+			if err != nil {
+				problems = append(problems, asProblems(files[i], err)...)
+				continue
+			}
+			files = append(files, importPath)
+		}
+	}
+	problems = append(problems, resourceManager.Validate()...)
+
+	// The injects can only be loaded once every file could be
+	if len(problems) == 0 {
+		_, err := k.loadResources(filePath)
+		if err != nil {
+			problems = append(problems, asProblems(filePath, err)...)
+		}
+	}
+
+	if len(problems) == 0 {
+		fmt.Println("Configuration is valid")
+		return nil
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	return fmt.Errorf("Found %d problem(s) in the configuration", len(problems))
+}
+
+// ********************
+// * HELPER FUNCTIONS *
+// ********************
+func asProblems(filePath string, err error) []Problem {
+	if configErr, ok := err.(*ConfigError); ok {
+		return configErr.Problems
+	}
+	return []Problem{{File: filePath, Message: err.Error()}}
+}
@@ -22,19 +22,25 @@ func init() {
 	flag.Set("v", "1")
 	flag.Set("logtostderr", "true")
 
-	flag.StringVar(&fname, "f", "kubeconfig.json", "Configuration file to use")
+	flag.StringVar(&fname, "f", "", "Configuration file to use (default kubeconfig.json, kubeconfig.yaml or kubeconfig.yml)")
 }
 
 func main() {
 	checkArgs()
 	parseArgs()
 
+	if fname == "" {
+		fname = kubemgr.DefaultConfigFile()
+	}
 	mgr := kubemgr.NewKubeMgr(fname)
 	mgr.Do(action, target)
 }
 
 func checkArgs() {
 	flag.Parse()
+	if len(flag.Args()) == 1 && kubemgr.TargetlessActions[flag.Args()[0]] {
+		return
+	}
 	if len(flag.Args()) != 2 {
 		glog.Errorf("Not enough arguments")
 		os.Exit(1)
@@ -43,6 +49,8 @@ func checkArgs() {
 
 func parseArgs() {
 	action = flag.Args()[0]
-	target = flag.Args()[1]
+	if len(flag.Args()) > 1 {
+		target = flag.Args()[1]
+	}
 	kubemgr.CheckAction(action)
 }